@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"hldsbot/hlds"
+	"hldsbot/settings"
 	"hldsbot/twhl"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -17,6 +21,7 @@ import (
 type Bot struct {
 	dg               *discordgo.Session
 	pool             *hlds.Pool
+	settings         *settings.Settings
 	steamRedirectURL string
 
 	// There's no way to carry a context through discordgo callbacks, we need
@@ -24,12 +29,25 @@ type Bot struct {
 	ctx context.Context //nolint:containedctx
 
 	removeHandler func()
+
+	sessionsMutex sync.Mutex
+	sessions      map[hlds.ServerID]session
+
+	mapChoicesMutex sync.Mutex
+	mapChoices      map[string]pendingMapChoice // by select menu custom ID
+}
+
+// Links a server to the Discord user that started it.
+type session struct {
+	ownerID   string
+	channelID string
 }
 
 func New(
 	token string,
 	steamRedirectURL string,
 	pool *hlds.Pool,
+	settings *settings.Settings,
 ) (*Bot, error) {
 	dg, err := discordgo.New("Bot " + token)
 	if err != nil {
@@ -40,7 +58,10 @@ func New(
 		dg:               dg,
 		steamRedirectURL: steamRedirectURL,
 		pool:             pool,
+		settings:         settings,
 		ctx:              context.Background(),
+		sessions:         make(map[hlds.ServerID]session),
+		mapChoices:       make(map[string]pendingMapChoice),
 	}, nil
 }
 
@@ -53,6 +74,8 @@ func (bot *Bot) Run(ctx context.Context) error {
 	}
 	defer bot.close()
 
+	bot.pool.SetRemoveHandler(bot.onServerRemoved)
+
 	if err := bot.registerCommands(); err != nil {
 		return fmt.Errorf("unable to register commands: %w", err)
 	}
@@ -74,12 +97,24 @@ func (bot *Bot) close() {
 	}
 }
 
+const serverLifetime = 1 * time.Hour
+
 type handler func(*discordgo.Session, *discordgo.InteractionCreate)
 
 func (bot *Bot) registerCommands() error {
+	var presetChoices = make([]*discordgo.ApplicationCommandOptionChoice, 0, len(bot.settings.PresetNames()))
+	for _, v := range bot.settings.PresetNames() {
+		presetChoices = append(presetChoices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  v,
+			Value: v,
+		})
+	}
+
 	var (
 		guildID          = ""
 		minID    float64 = 1
+		minSlots float64 = 2
+		maxSlots float64 = hlds.ServerPlayerSlots
 		commands         = []*discordgo.ApplicationCommand{
 			{
 				Name:        "hlds",
@@ -92,13 +127,59 @@ func (bot *Bot) registerCommands() error {
 						Required:    true,
 						MinValue:    &minID,
 					},
+					{
+						Name:        "max-players",
+						Description: fmt.Sprintf("Player slots of the server, defaults to %d.", defaultMaxPlayers),
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						MinValue:    &minSlots,
+						MaxValue:    maxSlots,
+					},
+					{
+						Name:        "spectate",
+						Description: "Start an HLTV proxy so spectators don't take a player slot.",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+					{
+						Name:        "record-demos",
+						Description: "Record demos of each map played, links are posted when the server shuts down.",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+					{
+						Name:        "preset",
+						Description: "Server configuration preset, defaults to " + settings.DefaultPreset + ".",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Choices:     presetChoices,
+					},
+					{
+						Name:        "plugins",
+						Description: "Comma-separated list of plugin bundles to enable on top of the preset ones.",
+						Type:        discordgo.ApplicationCommandOptionString,
+					},
 				},
 			},
+			configCommand(presetChoices),
+			{
+				Name:        "hlds-changelevel",
+				Description: "Switch your running HLDM server to another map.",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "vault-id",
+						Description: "Numerical ID of a TWHL Vault HLDM map.",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    true,
+						MinValue:    &minID,
+					},
+				},
+			},
+			rconCommand(),
 		}
 	)
 
 	handlers := map[string]handler{
-		"hlds": bot.commandHandlerHLDS,
+		"hlds":             bot.commandHandlerHLDS,
+		"hlds-changelevel": bot.commandHandlerChangeLevel,
+		"hlds-config":      bot.commandHandlerConfig,
+		"hlds-rcon":        bot.commandHandlerRCON,
 	}
 
 	var errs = make([]error, 0, len(commands))
@@ -118,15 +199,25 @@ func (bot *Bot) registerCommands() error {
 		}
 
 		logInteraction(i)
-		if h, ok := handlers[i.ApplicationCommandData().Name]; ok {
-			h(s, i)
+		switch i.Type {
+		case discordgo.InteractionApplicationCommand:
+			if h, ok := handlers[i.ApplicationCommandData().Name]; ok {
+				h(s, i)
+			}
+		case discordgo.InteractionMessageComponent:
+			switch customID := i.MessageComponentData().CustomID; {
+			case strings.HasPrefix(customID, mapChoiceCustomIDPrefix):
+				bot.handleMapChoice(s, i)
+			case strings.HasPrefix(customID, launchAnywayCustomIDPrefix):
+				bot.handleLaunchAnyway(s, i)
+			}
 		}
 	})
 
 	return errors.Join(errs...)
 }
 
-func logInteraction(i *discordgo.InteractionCreate) {
+func interactionUser(i *discordgo.InteractionCreate) discordgo.User {
 	var user discordgo.User
 	if i.User != nil {
 		user = *i.User
@@ -134,8 +225,37 @@ func logInteraction(i *discordgo.InteractionCreate) {
 		user = *i.Member.User
 	}
 
+	return user
+}
+
+// Name shown to other members of the guild.
+func interactionUserDisplayName(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.Nick != "" {
+		return i.Member.Nick
+	}
+
+	user := interactionUser(i)
+	if user.GlobalName != "" {
+		return user.GlobalName
+	}
+
+	return user.Username
+}
+
+func logInteraction(i *discordgo.InteractionCreate) {
+	var (
+		user = interactionUser(i)
+		data any
+	)
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data = i.ApplicationCommandData()
+	case discordgo.InteractionMessageComponent:
+		data = i.MessageComponentData()
+	}
+
 	log.Info().
-		Interface("command", i.ApplicationCommandData()).
+		Interface("command", data).
 		Str("GuildID", i.GuildID).
 		Str("ChannelID", i.ChannelID).
 		Str("UserID", user.ID).
@@ -161,35 +281,127 @@ func getOption(i *discordgo.InteractionCreate, name string) (*discordgo.Applicat
 	return nil, false
 }
 
-func (bot *Bot) commandHandlerHLDS(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// Player slots of servers started without the max-players option.
+const defaultMaxPlayers = 16
+
+// Options of the /hlds command, kept around while the user picks maps.
+type hldsRequest struct {
+	vaultID      int
+	presetName   string
+	extraPlugins []string
+	maxPlayers   int
+	spectate     bool
+	recordDemos  bool
+
+	// Set by the "Launch anyway" button, see checkPlayability.
+	ignorePlayability bool
+}
+
+func parseHLDSRequest(i *discordgo.InteractionCreate) (hldsRequest, error) {
+	var req = hldsRequest{
+		presetName: settings.DefaultPreset,
+		maxPlayers: defaultMaxPlayers,
+	}
+
 	idOption, ok := getOption(i, "vault-id")
 	if !ok {
-		log.Error().Err(errors.New("missing vault-id option")).Msg("")
+		return req, errors.New("missing vault-id option")
+	}
+	req.vaultID = int(idOption.IntValue())
+
+	if preset, ok := getOption(i, "preset"); ok {
+		req.presetName = preset.StringValue()
+	}
+
+	if plugins, ok := getOption(i, "plugins"); ok {
+		for _, v := range strings.Split(plugins.StringValue(), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				req.extraPlugins = append(req.extraPlugins, v)
+			}
+		}
+	}
+
+	if maxPlayers, ok := getOption(i, "max-players"); ok {
+		req.maxPlayers = int(maxPlayers.IntValue())
+	}
+
+	if spectate, ok := getOption(i, "spectate"); ok {
+		req.spectate = spectate.BoolValue()
+	}
+	if record, ok := getOption(i, "record-demos"); ok {
+		req.recordDemos = record.BoolValue()
+	}
+
+	return req, nil
+}
+
+func (bot *Bot) commandHandlerHLDS(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	req, err := parseHLDSRequest(i)
+	if err != nil {
+		log.Error().Err(err).Msg("")
 		return
 	}
-	id := int(idOption.IntValue())
 
-	if err := hldsPleaseWaitResponse(s, i); err != nil {
+	bot.startServer(s, i, req, nil)
+}
+
+// mapNames can be left empty, the user will be asked to pick maps if the
+// Vault item contains more than one.
+func (bot *Bot) startServer(
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	req hldsRequest,
+	mapNames []string,
+) {
+	if err := pleaseWaitResponse(s, i, "Creating server, please wait for a few seconds…"); err != nil {
 		log.Error().Err(err).Msg("unable to send waiting response")
 		// Other responses are follow-ups, it's no use continuing.
 		return
 	}
 
-	addonsDir, mapName, err := twhl.FetchAndExtractVaultMap(bot.ctx, id)
+	vaultMap, err := twhl.FetchAndExtractVaultMap(bot.ctx, req.vaultID, mapNames)
 	if err != nil {
+		var errChoice *twhl.MapChoiceError
+		if errors.As(err, &errChoice) {
+			bot.offerMapChoice(s, i, errChoice, 0, func(s *discordgo.Session, i *discordgo.InteractionCreate, maps []string) {
+				bot.startServer(s, i, req, maps)
+			})
+			return
+		}
+
 		log.Error().Err(err).Msg("unable to fetch and extract vault item")
 		errorResponse(s, i, err, "Could not fetch TWHL Vault item.")
 		return
 	}
 
+	issues := checkPlayability(vaultMap, req.maxPlayers)
+	if hlds.HasFatalPlayabilityIssue(issues) && !req.ignorePlayability {
+		bot.offerLaunchAnyway(s, i, vaultMap, issues, func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			req.ignorePlayability = true
+			bot.startServer(s, i, req, mapNames)
+		})
+		return
+	}
+
+	archiveReportResponse(s, i, vaultMap)
+	playabilityResponse(s, i, issues)
+
+	var presetName = req.presetName
+	layers, err := bot.settings.CVarLayers(i.GuildID, presetName, hlds.CVars{
+		"sv_allow_shaders": "1",
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to get server configuration")
+		errorResponse(s, i, err, "Could not create server configuration.")
+		return
+	}
+
 	cfg, err := hlds.NewServerConfig(
-		1*time.Hour,
-		addonsDir,
-		2,
-		[]string{mapName},
-		map[string]string{
-			"sv_allow_shaders": "1",
-		},
+		serverLifetime,
+		vaultMap.Dir,
+		req.maxPlayers,
+		vaultMap.Maps,
+		layers,
 	)
 	if err != nil {
 		log.Error().Err(err).Msg("unable to create server config")
@@ -197,6 +409,33 @@ func (bot *Bot) commandHandlerHLDS(s *discordgo.Session, i *discordgo.Interactio
 		return
 	}
 
+	branding, err := bot.renderBranding(i, vaultMap, time.Now().Add(serverLifetime))
+	if err != nil {
+		log.Error().Err(err).Msg("unable to render server branding")
+	} else if err := cfg.SetBranding(branding); err != nil {
+		log.Error().Err(err).Msg("unable to set server branding")
+	}
+
+	preset, _ := bot.settings.Preset(presetName)
+	if preset.Match {
+		cfg.EnableMatch()
+	}
+
+	bundles, err := bot.settings.PluginBundles(presetName, req.extraPlugins)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to get plugin bundles")
+		errorResponse(s, i, err, "Could not create server configuration.")
+		return
+	}
+	cfg.AddPluginBundles(bundles...)
+
+	if req.spectate {
+		cfg.EnableHLTV()
+	}
+	if req.recordDemos {
+		cfg.EnableDemoRecording()
+	}
+
 	server, err := bot.pool.AddServer(bot.ctx, cfg)
 	if err != nil {
 		log.Error().Err(err).Msg("unable to start server")
@@ -204,34 +443,199 @@ func (bot *Bot) commandHandlerHLDS(s *discordgo.Session, i *discordgo.Interactio
 		return
 	}
 
-	if err := bot.hldsResponse(s, i, server); err != nil {
+	bot.addSession(server.ID(), session{
+		ownerID:   interactionUser(i).ID,
+		channelID: i.ChannelID,
+	})
+
+	if err := bot.hldsResponse(s, i, server, mapPreview(vaultMap)); err != nil {
 		log.Error().Err(err).Msg("unable to respond to command")
 	}
 
-	if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-		Content: fmt.Sprintf("```rcon_password \"%s\"```", server.CVar("rcon_password")),
-		Flags:   discordgo.MessageFlagsEphemeral,
-	}); err != nil {
-		log.Error().Err(err).Msg("unable to echo rcon_password")
+	if preset.Match {
+		go bot.runMatch(server.ID(), i.ChannelID, preset.Warmup())
+	}
+}
+
+func (bot *Bot) commandHandlerChangeLevel(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	idOption, ok := getOption(i, "vault-id")
+	if !ok {
+		log.Error().Err(errors.New("missing vault-id option")).Msg("")
+		return
+	}
+
+	bot.changeLevel(s, i, int(idOption.IntValue()), nil, false)
+}
+
+// ignorePlayability is set by the "Launch anyway" button, see checkPlayability.
+func (bot *Bot) changeLevel(
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	id int,
+	mapNames []string,
+	ignorePlayability bool,
+) {
+	serverID, ok := bot.findSessionByOwner(interactionUser(i).ID)
+	if !ok {
+		ephemeralResponse(s, i, "You don't have a running server, use `/hlds` to start one.")
+		return
+	}
+
+	if err := pleaseWaitResponse(s, i, "Changing level, please wait for a few seconds…"); err != nil {
+		log.Error().Err(err).Msg("unable to send waiting response")
+		return
+	}
+
+	vaultMap, err := twhl.FetchAndExtractVaultMap(bot.ctx, id, mapNames)
+	if err != nil {
+		var errChoice *twhl.MapChoiceError
+		if errors.As(err, &errChoice) {
+			bot.offerMapChoice(s, i, errChoice, 1, func(s *discordgo.Session, i *discordgo.InteractionCreate, maps []string) {
+				bot.changeLevel(s, i, id, maps, ignorePlayability)
+			})
+			return
+		}
+
+		log.Error().Err(err).Msg("unable to fetch and extract vault item")
+		errorResponse(s, i, err, "Could not fetch TWHL Vault item.")
+		return
+	}
+
+	server, ok := bot.pool.GetServer(serverID)
+	if !ok {
+		if err := os.RemoveAll(vaultMap.Dir); err != nil {
+			log.Error().Err(err).Msg("unable to remove extracted content")
+		}
+		errorResponse(s, i, errors.New("server not found"), "Your server shut down, use `/hlds` to start a new one.")
+		return
+	}
+
+	issues := checkPlayability(vaultMap, server.MaxPlayers())
+	if hlds.HasFatalPlayabilityIssue(issues) && !ignorePlayability {
+		bot.offerLaunchAnyway(s, i, vaultMap, issues, func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.changeLevel(s, i, id, mapNames, true)
+		})
+		return
+	}
+
+	archiveReportResponse(s, i, vaultMap)
+	playabilityResponse(s, i, issues)
+
+	branding, err := bot.renderBranding(i, vaultMap, server.ExpiresAt())
+	if err != nil {
+		log.Error().Err(err).Msg("unable to render server branding")
+	}
+
+	// The extracted content is moved to the server by ChangeLevel.
+	preview := mapPreview(vaultMap)
+
+	if err := bot.pool.ChangeLevel(bot.ctx, serverID, vaultMap.Dir, vaultMap.MapName, branding); err != nil {
+		log.Error().Err(err).Msg("unable to change level")
+		errorResponse(s, i, err, "Could not change level.")
+		return
+	}
+
+	params := &discordgo.WebhookParams{
+		Content: fmt.Sprintf("Level changed to `%s`.", vaultMap.MapName),
+	}
+	if preview != nil {
+		params.Files = []*discordgo.File{preview}
+	}
+
+	if _, err := s.FollowupMessageCreate(i.Interaction, true, params); err != nil {
+		log.Error().Err(err).Msg("unable to respond to command")
+	}
+}
+
+// Returns the most recent server started by the given user that is still
+// running.
+func (bot *Bot) findSessionByOwner(ownerID string) (hlds.ServerID, bool) {
+	bot.sessionsMutex.Lock()
+	defer bot.sessionsMutex.Unlock()
+
+	var (
+		ret       hlds.ServerID
+		expiresAt time.Time
+	)
+	for id, v := range bot.sessions {
+		server, ok := bot.pool.GetServer(id)
+		if !ok {
+			delete(bot.sessions, id)
+			continue
+		}
+
+		if v.ownerID == ownerID && server.ExpiresAt().After(expiresAt) {
+			ret, expiresAt = id, server.ExpiresAt()
+		}
+	}
+
+	return ret, ret != ""
+}
+
+func (bot *Bot) addSession(id hlds.ServerID, sess session) {
+	bot.sessionsMutex.Lock()
+	defer bot.sessionsMutex.Unlock()
+
+	bot.sessions[id] = sess
+}
+
+// Forgets about the session and posts demo links if any were recorded.
+func (bot *Bot) onServerRemoved(closed hlds.ClosedServer) {
+	bot.sessionsMutex.Lock()
+	sess, ok := bot.sessions[closed.ID]
+	delete(bot.sessions, closed.ID)
+	bot.sessionsMutex.Unlock()
+
+	if !ok || len(closed.DemoURLs) == 0 {
+		return
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "<@%s> your session ended, demos are available until <t:%d:f>:\n",
+		sess.ownerID, closed.DemosExpireAt.Unix(),
+	)
+	for _, v := range closed.DemoURLs {
+		fmt.Fprintf(&msg, "- %s\n", v)
+	}
+
+	if _, err := bot.dg.ChannelMessageSend(sess.channelID, msg.String()); err != nil {
+		log.Error().Err(err).Msg("unable to post demo links")
 	}
 }
 
 func errorResponse(s *discordgo.Session, i *discordgo.InteractionCreate, err error, fallback string) {
 	var (
-		msg    = fallback
-		errCap *hlds.AtCapacityError
+		msg      = fallback
+		errCap   *hlds.AtCapacityError
+		errLimit *hlds.LimitError
+		errStock *hlds.StockOverrideError
 	)
 	switch {
 	case errors.Is(err, hlds.MissingBSPErr):
 		msg = "No .bsp file found in archive."
 	case errors.Is(err, hlds.MultipleBSPErr):
-		msg = "Multiple .bsp files found in archive, there can be only one."
+		msg = "Multiple .bsp files found in archive, pick one using the menu."
+	case errors.Is(err, hlds.UnknownMapErr):
+		msg = "Selected map not found in archive."
 	case errors.Is(err, hlds.InvalidPathErr):
 		msg = "File or directory name with non-unicode characters found in archive."
 	case errors.Is(err, hlds.UnknownArchiveErr):
-		msg = "Unsupported archive format, only ZIP and 7z are supported."
+		msg = "Unsupported archive format, only ZIP, 7z, RAR and tar (optionally gzip, bzip2 or xz compressed) are supported."
+	case errors.As(err, &errLimit):
+		msg = limitErrorMessage(errLimit)
+	case errors.As(err, &errStock):
+		msg = fmt.Sprintf("Archive replaces _Half-Life_ files, this is not allowed: `%s`.", strings.Join(errStock.Paths, "`, `"))
+		if len(msg) > 1900 {
+			msg = "Archive replaces _Half-Life_ files, this is not allowed."
+		}
 	case errors.As(err, &errCap):
 		msg = fmt.Sprintf("All servers are busy, one will be freed <t:%d:R>.", err)
+	case errors.Is(err, settings.UnknownPresetErr):
+		msg = "Unknown preset."
+	case errors.Is(err, hlds.UnknownPluginBundleErr):
+		msg = "Unknown plugin bundle, available bundles are listed in `/hlds-config show`."
+	case errors.Is(err, hlds.ServerNotFoundErr):
+		msg = "Your server is not running anymore."
 	case errors.Is(err, twhl.ErrWrongCategory):
 		msg = "Vault item is not a _Half-Life: Deathmatch_ map."
 	}
@@ -244,13 +648,32 @@ func errorResponse(s *discordgo.Session, i *discordgo.InteractionCreate, err err
 	}
 }
 
+func limitErrorMessage(err *hlds.LimitError) string {
+	switch err.Limit {
+	case "MaxTotalSize":
+		return fmt.Sprintf("Archive is too large once extracted, the limit is %d MiB.", err.Max>>20)
+	case "MaxFileSize":
+		return fmt.Sprintf("`%s` is too large, the limit is %d MiB per file.", err.Path, err.Max>>20)
+	case "MaxFiles":
+		return fmt.Sprintf("Archive contains too many files, the limit is %d.", err.Max)
+	case "MaxPathDepth":
+		return fmt.Sprintf("`%s` is nested too deep in the archive.", err.Path)
+	case "MaxCompressionRatio":
+		return "Archive compression ratio is suspiciously high, refusing to extract it."
+	}
+
+	return "Archive exceeds extraction limits."
+}
+
 //go:embed hlds_response.tpl
 var hldsResponseTPL string
 
+// preview can be nil.
 func (bot *Bot) hldsResponse(
 	s *discordgo.Session,
 	i *discordgo.InteractionCreate,
 	server hlds.Server,
+	preview *discordgo.File,
 ) error {
 	if err := s.InteractionResponseDelete(i.Interaction); err != nil {
 		log.Error().Err(err).Msg("cannot remove interaction")
@@ -261,20 +684,33 @@ func (bot *Bot) hldsResponse(
 		password = server.CVar("sv_password")
 	)
 
-	_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+	var buttons = []discordgo.MessageComponent{
+		&discordgo.Button{
+			Label: fmt.Sprintf("Join %s", server.CVar("hostname")),
+			Style: discordgo.LinkButton,
+			URL:   bot.generateConnectURL(host, password),
+		},
+	}
+
+	if hltvHost, ok := server.HLTVHost(); ok {
+		buttons = append(buttons, &discordgo.Button{
+			Label: "Spectate",
+			Style: discordgo.LinkButton,
+			URL:   bot.generateConnectURL(hltvHost, password),
+		})
+	}
+
+	params := &discordgo.WebhookParams{
 		Content: fmt.Sprintf(hldsResponseTPL, password, host, server.ExpiresAt().Unix()),
 		Components: []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					&discordgo.Button{
-						Label: fmt.Sprintf("Join %s", server.CVar("hostname")),
-						Style: discordgo.LinkButton,
-						URL:   bot.generateConnectURL(host, password),
-					},
-				},
-			},
+			discordgo.ActionsRow{Components: buttons},
 		},
-	})
+	}
+	if preview != nil {
+		params.Files = []*discordgo.File{preview}
+	}
+
+	_, err := s.FollowupMessageCreate(i.Interaction, true, params)
 
 	return err
 }
@@ -293,15 +729,16 @@ func (bot *Bot) generateConnectURL(host, password string) string {
 	return ret.String()
 }
 
-func hldsPleaseWaitResponse(
+func pleaseWaitResponse(
 	s *discordgo.Session,
 	i *discordgo.InteractionCreate,
+	content string,
 ) error {
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Flags:   discordgo.MessageFlagsEphemeral,
-			Content: "Creating server, please wait for a few seconds…",
+			Content: content,
 		},
 	})
 }
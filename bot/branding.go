@@ -0,0 +1,140 @@
+package bot
+
+import (
+	_ "embed"
+	"fmt"
+	"hldsbot/hlds"
+	"hldsbot/settings"
+	"hldsbot/twhl"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	//go:embed hostname.tpl
+	defaultHostnameTPL string
+	//go:embed motd.tpl
+	defaultMOTDTPL string
+)
+
+// Data available to hostname and MOTD templates.
+type brandingData struct {
+	MapName   string
+	ItemName  string
+	Author    string
+	VaultURL  string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func newBrandingData(vaultMap twhl.VaultMap, owner string, expiresAt time.Time) brandingData {
+	return brandingData{
+		MapName:   vaultMap.MapName,
+		ItemName:  vaultMap.Item.Name,
+		Author:    vaultMap.Author.Name,
+		VaultURL:  vaultMap.Item.URL(),
+		Owner:     owner,
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Used to validate templates before saving them.
+var sampleBrandingData = brandingData{
+	MapName:   "crossfire",
+	ItemName:  "Crossfire",
+	Author:    "Valve",
+	VaultURL:  "https://twhl.info/vault/view/1",
+	Owner:     "someone",
+	ExpiresAt: time.Now(),
+}
+
+func (bot *Bot) renderBranding(
+	i *discordgo.InteractionCreate,
+	vaultMap twhl.VaultMap,
+	expiresAt time.Time,
+) (hlds.Branding, error) {
+	guild, err := bot.settings.Guild(i.GuildID)
+	if err != nil {
+		return hlds.Branding{}, fmt.Errorf("unable to get guild settings: %w", err)
+	}
+
+	return renderBranding(guild, newBrandingData(vaultMap, interactionUserDisplayName(i), expiresAt))
+}
+
+func renderBranding(guild settings.Guild, data brandingData) (hlds.Branding, error) {
+	var (
+		hostnameTPL = defaultHostnameTPL
+		motdTPL     = defaultMOTDTPL
+	)
+	if guild.HostnameTemplate != "" {
+		hostnameTPL = guild.HostnameTemplate
+	}
+	if guild.MOTDTemplate != "" {
+		motdTPL = guild.MOTDTemplate
+	}
+
+	hostname, err := renderTemplate(hostnameTPL, data)
+	if err != nil {
+		return hlds.Branding{}, fmt.Errorf("unable to render hostname: %w", err)
+	}
+
+	motd, err := renderTemplate(motdTPL, data)
+	if err != nil {
+		return hlds.Branding{}, fmt.Errorf("unable to render MOTD: %w", err)
+	}
+
+	return hlds.Branding{
+		Hostname: sanitizeHostname(hostname),
+		MOTD:     sanitizeMOTD(motd),
+	}, nil
+}
+
+func renderTemplate(text string, data brandingData) (string, error) {
+	tpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse template: %w", err)
+	}
+
+	var out strings.Builder
+	if err := tpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("unable to execute template: %w", err)
+	}
+
+	return out.String(), nil
+}
+
+// Vault item names and Discord names can contain anything, the hostname is a
+// single line of ASCII without quotes.
+func sanitizeHostname(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+
+	if len(s) > hlds.MaxHostnameLength {
+		s = s[:hlds.MaxHostnameLength]
+	}
+
+	return s
+}
+
+func sanitizeMOTD(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	if len(s) > hlds.MaxMOTDLength {
+		s = strings.ToValidUTF8(s[:hlds.MaxMOTDLength], "")
+	}
+
+	return s
+}
@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"hldsbot/settings"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBranding(t *testing.T) {
+	branding, err := renderBranding(settings.Guild{}, sampleBrandingData)
+	require.NoError(t, err)
+	require.Equal(t, "HLDSBot crossfire playtest", branding.Hostname)
+	require.Contains(t, branding.MOTD, "Crossfire by Valve")
+	require.Contains(t, branding.MOTD, sampleBrandingData.VaultURL)
+
+	data := sampleBrandingData
+	data.ItemName = `"Crossfire" ☢` + strings.Repeat("!", 100)
+	branding, err = renderBranding(settings.Guild{HostnameTemplate: "{{.ItemName}}\nquit"}, data)
+	require.NoError(t, err)
+	require.Equal(t, "Crossfire "+strings.Repeat("!", 53), branding.Hostname, "hostname is sanitized")
+
+	_, err = renderBranding(settings.Guild{HostnameTemplate: "{{.Nope}}"}, data)
+	require.Error(t, err, "unknown fields are rejected")
+}
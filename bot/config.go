@@ -0,0 +1,350 @@
+package bot
+
+import (
+	"fmt"
+	"hldsbot/hlds"
+	"hldsbot/settings"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+func configCommand(presetChoices []*discordgo.ApplicationCommandOptionChoice) *discordgo.ApplicationCommand {
+	var dmPermission = false
+
+	return &discordgo.ApplicationCommand{
+		Name:         "hlds-config",
+		Description:  "View or change the HLDM server configuration for this Discord server.",
+		DMPermission: &dmPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "show",
+				Description: "Show the effective server configuration.",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "preset",
+						Description: "Preset to apply, defaults to " + settings.DefaultPreset + ".",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Choices:     presetChoices,
+					},
+				},
+			},
+			{
+				Name:        "set",
+				Description: "Override a cvar on all servers started from this Discord server.",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "cvar",
+						Description: "Name of the cvar, eg. mp_fraglimit.",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:        "value",
+						Description: "Value of the cvar.",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+				},
+			},
+			{
+				Name:        "branding",
+				Description: "Change the hostname and MOTD templates, see /hlds-config show for available fields.",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "hostname",
+						Description: "Hostname template, eg. {{.ItemName}} by {{.Author}}",
+						Type:        discordgo.ApplicationCommandOptionString,
+					},
+					{
+						Name:        "motd",
+						Description: `MOTD template, use \n for line breaks.`,
+						Type:        discordgo.ApplicationCommandOptionString,
+					},
+					{
+						Name:        "reset",
+						Description: "Go back to the default templates.",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+				},
+			},
+			{
+				Name:        "rcon",
+				Description: "Set the commands a role can run using /hlds-rcon on their own server.",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "role",
+						Description: "Role to configure, @everyone applies to all members.",
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Required:    true,
+					},
+					{
+						Name:        "commands",
+						Description: "Comma-separated list of command names, leave empty to remove the role.",
+						Type:        discordgo.ApplicationCommandOptionString,
+					},
+				},
+			},
+			{
+				Name:        "unset",
+				Description: "Remove a cvar override.",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "cvar",
+						Description: "Name of the cvar, eg. mp_fraglimit.",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func getSubOption(
+	opt *discordgo.ApplicationCommandInteractionDataOption,
+	name string,
+) (*discordgo.ApplicationCommandInteractionDataOption, bool) {
+	for _, v := range opt.Options {
+		if v.Name == name {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// Changing the configuration requires the same permission as changing the
+// Discord server settings.
+func canManageGuild(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionManageServer != 0
+}
+
+func (bot *Bot) commandHandlerConfig(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := i.ApplicationCommandData().Options
+	if len(options) != 1 {
+		log.Error().Msg("missing hlds-config subcommand")
+		return
+	}
+
+	var (
+		sub = options[0]
+		msg string
+		err error
+	)
+
+	switch sub.Name {
+	case "show":
+		msg, err = bot.showConfig(i.GuildID, sub)
+	case "branding":
+		if !canManageGuild(i) {
+			msg = "You need the _Manage Server_ permission to change the configuration."
+			break
+		}
+
+		msg, err = bot.setBranding(i.GuildID, sub)
+	case "rcon":
+		if !canManageGuild(i) {
+			msg = "You need the _Manage Server_ permission to change the configuration."
+			break
+		}
+
+		msg, err = bot.setRCONCommands(i.GuildID, sub)
+	case "set", "unset":
+		if !canManageGuild(i) {
+			msg = "You need the _Manage Server_ permission to change the configuration."
+			break
+		}
+
+		cvar, _ := getSubOption(sub, "cvar")
+		if sub.Name == "set" {
+			value, _ := getSubOption(sub, "value")
+			err = bot.settings.SetGuildCVar(i.GuildID, cvar.StringValue(), value.StringValue())
+			msg = fmt.Sprintf("`%s` set to `%s`.", cvar.StringValue(), value.StringValue())
+		} else {
+			err = bot.settings.UnsetGuildCVar(i.GuildID, cvar.StringValue())
+			msg = fmt.Sprintf("`%s` override removed.", cvar.StringValue())
+		}
+	default:
+		log.Error().Str("subcommand", sub.Name).Msg("unknown hlds-config subcommand")
+		return
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("subcommand", sub.Name).Msg("unable to handle config command")
+		msg = fmt.Sprintf("Could not %s configuration: %s", sub.Name, err)
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: msg,
+		},
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to respond to command")
+	}
+}
+
+func (bot *Bot) showConfig(guildID string, sub *discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	var presetName = settings.DefaultPreset
+	if preset, ok := getSubOption(sub, "preset"); ok {
+		presetName = preset.StringValue()
+	}
+
+	layers, err := bot.settings.CVarLayers(guildID, presetName, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		resolved = hlds.ResolveCVars(layers)
+		keys     = make([]string, 0, len(resolved))
+		out      strings.Builder
+	)
+	for k := range resolved {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	fmt.Fprintf(&out, "Effective configuration using preset `%s`, ", presetName)
+	out.WriteString("later layers override earlier ones: ")
+	for i, v := range layers {
+		if i > 0 {
+			out.WriteString(" → ")
+		}
+		out.WriteString(v.Name)
+	}
+	out.WriteString(".\n```\n")
+	for _, k := range keys {
+		fmt.Fprintf(&out, "%-20s %-10s // %s\n", k, resolved[k].Value, resolved[k].Layer)
+	}
+	out.WriteString("```")
+
+	preset, _ := bot.settings.Preset(presetName)
+	if len(preset.Plugins) > 0 {
+		fmt.Fprintf(&out, "\nPlugin bundles enabled by the preset: `%s`.", strings.Join(preset.Plugins, "`, `"))
+	}
+	if names := bot.settings.PluginBundleNames(); len(names) > 0 {
+		fmt.Fprintf(&out, "\nAvailable plugin bundles: `%s`.", strings.Join(names, "`, `"))
+	}
+
+	guild, err := bot.settings.Guild(guildID)
+	if err != nil {
+		return "", err
+	}
+	hostnameTPL, motdTPL := guild.HostnameTemplate, guild.MOTDTemplate
+	if hostnameTPL == "" {
+		hostnameTPL = defaultHostnameTPL
+	}
+	if motdTPL == "" {
+		motdTPL = defaultMOTDTPL
+	}
+	out.WriteString("\nCommands allowed in `/hlds-rcon`: ")
+	if guild.RCONAllowlist == nil {
+		fmt.Fprintf(&out, "`%s` for everyone.", strings.Join(settings.DefaultRCONCommands, "`, `"))
+	} else {
+		roles := make([]string, 0, len(guild.RCONAllowlist))
+		for k := range guild.RCONAllowlist {
+			roles = append(roles, k)
+		}
+		slices.Sort(roles)
+
+		if len(roles) == 0 {
+			out.WriteString("none.")
+		}
+		for _, role := range roles {
+			fmt.Fprintf(&out, "\n- %s: `%s`", roleMention(guildID, role), strings.Join(guild.RCONAllowlist[role], "`, `"))
+		}
+	}
+
+	fmt.Fprintf(&out, "\nHostname template:```\n%s```MOTD template:```\n%s```", hostnameTPL, motdTPL)
+	out.WriteString("Template fields: `{{.MapName}}`, `{{.ItemName}}`, `{{.Author}}`, `{{.VaultURL}}`, `{{.Owner}}`, `{{.ExpiresAt}}`.")
+
+	return out.String(), nil
+}
+
+func (bot *Bot) setBranding(guildID string, sub *discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	if reset, ok := getSubOption(sub, "reset"); ok && reset.BoolValue() {
+		if err := bot.settings.SetGuildHostnameTemplate(guildID, ""); err != nil {
+			return "", err
+		}
+		if err := bot.settings.SetGuildMOTDTemplate(guildID, ""); err != nil {
+			return "", err
+		}
+
+		return "Branding templates reset to their defaults.", nil
+	}
+
+	var changed []string
+	if hostname, ok := getSubOption(sub, "hostname"); ok {
+		tpl := hostname.StringValue()
+		if _, err := renderTemplate(tpl, sampleBrandingData); err != nil {
+			return "", err
+		}
+
+		if err := bot.settings.SetGuildHostnameTemplate(guildID, tpl); err != nil {
+			return "", err
+		}
+		changed = append(changed, "hostname")
+	}
+
+	if motd, ok := getSubOption(sub, "motd"); ok {
+		tpl := strings.ReplaceAll(motd.StringValue(), `\n`, "\n")
+		if _, err := renderTemplate(tpl, sampleBrandingData); err != nil {
+			return "", err
+		}
+
+		if err := bot.settings.SetGuildMOTDTemplate(guildID, tpl); err != nil {
+			return "", err
+		}
+		changed = append(changed, "MOTD")
+	}
+
+	if len(changed) == 0 {
+		return "Nothing to change.", nil
+	}
+
+	return fmt.Sprintf("Updated %s template.", strings.Join(changed, " and ")), nil
+}
+
+func (bot *Bot) setRCONCommands(guildID string, sub *discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	role, _ := getSubOption(sub, "role")
+	roleID, _ := role.Value.(string)
+
+	var commands []string
+	if opt, ok := getSubOption(sub, "commands"); ok {
+		for _, v := range strings.Split(opt.StringValue(), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				commands = append(commands, v)
+			}
+		}
+	}
+
+	if err := bot.settings.SetGuildRCONCommands(guildID, roleID, commands); err != nil {
+		return "", err
+	}
+
+	if len(commands) == 0 {
+		return fmt.Sprintf("%s can't run any command anymore.", roleMention(guildID, roleID)), nil
+	}
+
+	return fmt.Sprintf("%s can now run `%s`.", roleMention(guildID, roleID), strings.Join(commands, "`, `")), nil
+}
+
+// The @everyone role has the same ID as the guild and can't be mentioned
+// like other roles.
+func roleMention(guildID, roleID string) string {
+	if roleID == guildID {
+		return "@everyone"
+	}
+
+	return fmt.Sprintf("<@&%s>", roleID)
+}
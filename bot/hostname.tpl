@@ -0,0 +1 @@
+HLDSBot {{.MapName}} playtest
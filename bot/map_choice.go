@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hldsbot/twhl"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	mapChoiceCustomIDPrefix = "hlds-map-choice:"
+	mapChoiceTTL            = 10 * time.Minute
+
+	// Discord select menus can't have more options.
+	maxMapChoices = 25
+)
+
+// A command waiting for its user to pick maps from a multi-map archive.
+type pendingMapChoice struct {
+	ownerID   string
+	expiresAt time.Time
+	run       func(s *discordgo.Session, i *discordgo.InteractionCreate, maps []string)
+}
+
+// Replaces the waiting response with a select menu of the maps found in
+// the archive, run will be called with the interaction of the selection.
+// maxValues limits the number of maps that can be picked, 0 for no limit.
+func (bot *Bot) offerMapChoice(
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	errChoice *twhl.MapChoiceError,
+	maxValues int,
+	run func(s *discordgo.Session, i *discordgo.InteractionCreate, maps []string),
+) {
+	customID, err := bot.addMapChoice(mapChoiceCustomIDPrefix, pendingMapChoice{
+		ownerID:   interactionUser(i).ID,
+		expiresAt: time.Now().Add(mapChoiceTTL),
+		run:       run,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to store map choice")
+		errorResponse(s, i, err, "Could not list the maps in the archive.")
+		return
+	}
+
+	var maps = errChoice.Maps
+	if len(maps) > maxMapChoices {
+		maps = maps[:maxMapChoices]
+	}
+	if maxValues <= 0 || maxValues > len(maps) {
+		maxValues = len(maps)
+	}
+
+	var options = make([]discordgo.SelectMenuOption, 0, len(maps))
+	for _, v := range maps {
+		options = append(options, discordgo.SelectMenuOption{Label: v, Value: v})
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "_%s_ contains %d maps, ", errChoice.Item.Name, len(errChoice.Maps))
+	if maxValues == 1 {
+		content.WriteString("pick the one to play.")
+	} else {
+		content.WriteString("pick the ones to play, they will be played in alphabetical order.")
+	}
+	if len(maps) < len(errChoice.Maps) {
+		fmt.Fprintf(&content, " Only the first %d maps are listed.", len(maps))
+	}
+
+	var (
+		msg        = content.String()
+		minValues  = 1
+		components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  customID,
+					MinValues: &minValues,
+					MaxValues: maxValues,
+					Options:   options,
+				},
+			}},
+		}
+	)
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &msg,
+		Components: &components,
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to send map choice")
+	}
+}
+
+// Stores a pending choice, the returned custom ID starts with prefix so the
+// interaction handler can route the component to the right handler.
+func (bot *Bot) addMapChoice(prefix string, choice pendingMapChoice) (string, error) {
+	var buf = make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("unable to generate custom ID: %w", err)
+	}
+	customID := prefix + hex.EncodeToString(buf)
+
+	bot.mapChoicesMutex.Lock()
+	defer bot.mapChoicesMutex.Unlock()
+
+	for k, v := range bot.mapChoices {
+		if time.Now().After(v.expiresAt) {
+			delete(bot.mapChoices, k)
+		}
+	}
+	bot.mapChoices[customID] = choice
+
+	return customID, nil
+}
+
+func (bot *Bot) takeMapChoice(customID string) (pendingMapChoice, bool) {
+	bot.mapChoicesMutex.Lock()
+	defer bot.mapChoicesMutex.Unlock()
+
+	choice, ok := bot.mapChoices[customID]
+	delete(bot.mapChoices, customID)
+
+	return choice, ok && time.Now().Before(choice.expiresAt)
+}
+
+func (bot *Bot) handleMapChoice(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+
+	choice, ok := bot.takeMapChoice(data.CustomID)
+	if !ok {
+		ephemeralResponse(s, i, "This selection expired, please run the command again.")
+		return
+	}
+
+	if choice.ownerID != interactionUser(i).ID {
+		ephemeralResponse(s, i, "Only the member who ran the command can pick maps.")
+		return
+	}
+
+	choice.run(s, i, data.Values)
+}
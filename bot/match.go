@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"hldsbot/hlds"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+// Runs the match until intermission, posts the scoreboard and shuts the
+// server down. Meant to be run in its own goroutine.
+func (bot *Bot) runMatch(serverID hlds.ServerID, channelID string, warmup time.Duration) {
+	if _, err := bot.dg.ChannelMessageSend(channelID, fmt.Sprintf(
+		"Warmup in progress, the match starts <t:%d:R>.",
+		time.Now().Add(warmup).Unix(),
+	)); err != nil {
+		log.Error().Err(err).Msg("unable to announce warmup")
+	}
+
+	board, err := bot.pool.RunMatch(bot.ctx, serverID, warmup)
+	if err != nil {
+		if !errors.Is(err, hlds.MatchInterruptedErr) {
+			log.Error().Err(err).Str("id", serverID.String()).Msg("unable to follow match")
+		}
+		return
+	}
+
+	if _, err := bot.dg.ChannelMessageSendEmbed(channelID, scoreboardEmbed(board)); err != nil {
+		log.Error().Err(err).Msg("unable to post scoreboard")
+	}
+
+	log.Info().Str("id", serverID.String()).Msg("Match over, removing server.")
+	if err := bot.pool.RemoveServer(bot.ctx, serverID); err != nil {
+		log.Error().Err(err).Msg("unable to remove server after match")
+	}
+}
+
+func scoreboardEmbed(board hlds.Scoreboard) *discordgo.MessageEmbed {
+	var (
+		names  = make([]string, 0, len(board.Players))
+		frags  = make([]string, 0, len(board.Players))
+		deaths = make([]string, 0, len(board.Players))
+	)
+	for _, v := range board.Players {
+		names = append(names, escapeMarkdown(v.Name))
+		frags = append(frags, fmt.Sprint(v.Frags))
+		deaths = append(deaths, fmt.Sprint(v.Deaths))
+	}
+
+	var winner string
+	switch winners := board.Winners(); len(winners) {
+	case 0:
+		winner = "Nobody showed up."
+	case 1:
+		winner = fmt.Sprintf("**%s** wins!", escapeMarkdown(winners[0].Name))
+	default:
+		tied := make([]string, 0, len(winners))
+		for _, v := range winners {
+			tied = append(tied, "**"+escapeMarkdown(v.Name)+"**")
+		}
+		winner = fmt.Sprintf("Tie between %s.", strings.Join(tied, ", "))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Match results on %s", board.Map),
+		Description: winner,
+	}
+	if len(names) > 0 {
+		embed.Fields = []*discordgo.MessageEmbedField{
+			{Name: "Player", Value: strings.Join(names, "\n"), Inline: true},
+			{Name: "Frags", Value: strings.Join(frags, "\n"), Inline: true},
+			{Name: "Deaths", Value: strings.Join(deaths, "\n"), Inline: true},
+		}
+	}
+
+	return embed
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`,
+)
+
+// Player names are arbitrary.
+func escapeMarkdown(str string) string {
+	return markdownReplacer.Replace(str)
+}
@@ -0,0 +1,5 @@
+{{.ItemName}} by {{.Author}}
+{{.VaultURL}}
+
+Session started by {{.Owner}}.
+The server will shut down at {{.ExpiresAt.UTC.Format "15:04 MST"}}.
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"fmt"
+	"hldsbot/hlds"
+	"hldsbot/twhl"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+const launchAnywayCustomIDPrefix = "hlds-launch-anyway:"
+
+// Returns the deathmatch playability issues of every selected map on a
+// server with maxPlayers slots, messages are prefixed with the map name when
+// there are more than one.
+func checkPlayability(vaultMap twhl.VaultMap, maxPlayers int) []hlds.PlayabilityIssue {
+	var ret []hlds.PlayabilityIssue
+	for _, mapName := range vaultMap.Maps {
+		issues, err := hlds.CheckMapPlayability(vaultMap.Dir, mapName, maxPlayers)
+		if err != nil {
+			// Not our call, the server will tell.
+			log.Warn().Err(err).Str("map", mapName).Msg("unable to check map playability")
+			continue
+		}
+
+		for _, v := range issues {
+			if len(vaultMap.Maps) > 1 {
+				v.Message = fmt.Sprintf("`%s`: %s", mapName, v.Message)
+			}
+			ret = append(ret, v)
+		}
+	}
+
+	return ret
+}
+
+func writePlayabilityIssues(msg *strings.Builder, issues []hlds.PlayabilityIssue) {
+	for _, v := range issues {
+		if v.Severity == hlds.PlayabilityFatal {
+			msg.WriteString("- **" + v.Message + "**\n")
+		} else {
+			msg.WriteString("- " + v.Message + "\n")
+		}
+	}
+}
+
+// Replaces the waiting response with the issues found and a button, run
+// will be called with the interaction of the button. The extracted content
+// is discarded, it's cheap to get again from the Vault cache.
+func (bot *Bot) offerLaunchAnyway(
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	vaultMap twhl.VaultMap,
+	issues []hlds.PlayabilityIssue,
+	run func(s *discordgo.Session, i *discordgo.InteractionCreate),
+) {
+	if err := os.RemoveAll(vaultMap.Dir); err != nil {
+		log.Error().Err(err).Msg("unable to remove extracted content")
+	}
+
+	customID, err := bot.addMapChoice(launchAnywayCustomIDPrefix, pendingMapChoice{
+		ownerID:   interactionUser(i).ID,
+		expiresAt: time.Now().Add(mapChoiceTTL),
+		run: func(s *discordgo.Session, i *discordgo.InteractionCreate, _ []string) {
+			run(s, i)
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to store launch override")
+		errorResponse(s, i, err, "Could not check the map.")
+		return
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "_%s_ does not look playable in deathmatch:\n", vaultMap.Item.Name)
+	writePlayabilityIssues(&content, issues)
+	content.WriteString("You can still launch it if you know what you're doing.")
+
+	var (
+		msg        = content.String()
+		components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Launch anyway",
+					Style:    discordgo.DangerButton,
+					CustomID: customID,
+				},
+			}},
+		}
+	)
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &msg,
+		Components: &components,
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to send playability issues")
+	}
+}
+
+// Lets the requester know about what may go wrong while playing, sent
+// before the server starts.
+func playabilityResponse(s *discordgo.Session, i *discordgo.InteractionCreate, issues []hlds.PlayabilityIssue) {
+	if len(issues) == 0 {
+		return
+	}
+
+	var msg strings.Builder
+	msg.WriteString("The map may not play well in deathmatch:\n")
+	writePlayabilityIssues(&msg, issues)
+
+	if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: strings.TrimSpace(msg.String()),
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to send playability issues")
+	}
+}
+
+func (bot *Bot) handleLaunchAnyway(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	choice, ok := bot.takeMapChoice(i.MessageComponentData().CustomID)
+	if !ok {
+		ephemeralResponse(s, i, "This button expired, please run the command again.")
+		return
+	}
+
+	if choice.ownerID != interactionUser(i).ID {
+		ephemeralResponse(s, i, "Only the member who ran the command can launch the map.")
+		return
+	}
+
+	choice.run(s, i, nil)
+}
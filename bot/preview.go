@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"hldsbot/bsp"
+	"hldsbot/overview"
+	"hldsbot/twhl"
+	"image/png"
+	"path/filepath"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+const previewSize = 512
+
+// Renders a top-down view of the startup map to attach to responses,
+// returns nil if the map can't be rendered.
+func mapPreview(vaultMap twhl.VaultMap) *discordgo.File {
+	b, err := bsp.Open(filepath.Join(vaultMap.Dir, "maps", vaultMap.MapName+".bsp"))
+	if err != nil {
+		log.Warn().Err(err).Str("map", vaultMap.MapName).Msg("unable to read BSP for preview")
+		return nil
+	}
+
+	img, err := overview.Preview(b, previewSize)
+	if err != nil {
+		log.Warn().Err(err).Str("map", vaultMap.MapName).Msg("unable to render map preview")
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Error().Err(err).Msg("unable to encode map preview")
+		return nil
+	}
+
+	return &discordgo.File{
+		Name:        fmt.Sprintf("%s.png", vaultMap.MapName),
+		ContentType: "image/png",
+		Reader:      &buf,
+	}
+}
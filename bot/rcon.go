@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"hldsbot/settings"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+// Discord messages can't be longer than 2000 characters.
+const maxRCONOutputLength = 1800
+
+func rconCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        "hlds-rcon",
+		Description: "Run a console command on your running HLDM server.",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "command",
+				Description: "Command to run, eg. mp_timelimit 20",
+				Type:        discordgo.ApplicationCommandOptionString,
+				Required:    true,
+			},
+		},
+	}
+}
+
+func (bot *Bot) commandHandlerRCON(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	commandOption, ok := getOption(i, "command")
+	if !ok {
+		log.Error().Err(errors.New("missing command option")).Msg("")
+		return
+	}
+
+	var (
+		command = strings.TrimSpace(commandOption.StringValue())
+		user    = interactionUser(i)
+		entry   = settings.RCONAuditEntry{
+			Time:     time.Now(),
+			GuildID:  i.GuildID,
+			UserID:   user.ID,
+			Username: user.Username,
+			Command:  command,
+		}
+	)
+
+	serverID, ok := bot.findSessionByOwner(user.ID)
+	if !ok {
+		ephemeralResponse(s, i, "You don't have a running server, use `/hlds` to start one.")
+		return
+	}
+	entry.ServerID = serverID
+
+	allowed, err := bot.canRunRCONCommand(i, command)
+	entry.Allowed = allowed
+	if !allowed {
+		var msg = "You are not allowed to run this command, see `/hlds-config show` for allowed commands."
+		switch {
+		case errors.Is(err, settings.InvalidRCONCommandErr), errors.Is(err, settings.ForbiddenRCONCommandErr):
+			msg = fmt.Sprintf("Could not run command: %s.", err)
+		case err != nil:
+			log.Error().Err(err).Msg("unable to check rcon command")
+			msg = "Could not run command."
+		}
+
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		bot.auditRCON(entry)
+		ephemeralResponse(s, i, msg)
+
+		return
+	}
+
+	if err := pleaseWaitResponse(s, i, "Running command…"); err != nil {
+		log.Error().Err(err).Msg("unable to send waiting response")
+		return
+	}
+
+	out, err := bot.pool.RCON(bot.ctx, serverID, command)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	bot.auditRCON(entry)
+
+	if err != nil {
+		log.Error().Err(err).Msg("unable to run rcon command")
+		errorResponse(s, i, err, "Could not run command.")
+		return
+	}
+
+	if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: formatRCONOutput(command, out),
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to respond to command")
+	}
+}
+
+// Members with the Manage Server permission can run any command, others are
+// restricted to the allowlist of their roles.
+func (bot *Bot) canRunRCONCommand(i *discordgo.InteractionCreate, command string) (bool, error) {
+	name, err := settings.RCONCommandName(command)
+	if err != nil {
+		return false, err
+	}
+
+	if canManageGuild(i) {
+		return true, nil
+	}
+
+	guild, err := bot.settings.Guild(i.GuildID)
+	if err != nil {
+		return false, err
+	}
+
+	var roles []string
+	if i.Member != nil {
+		roles = i.Member.Roles
+	}
+
+	return guild.AllowsRCONCommand(i.GuildID, roles, name), nil
+}
+
+func (bot *Bot) auditRCON(entry settings.RCONAuditEntry) {
+	log.Info().
+		Str("user", entry.UserID).
+		Str("server", entry.ServerID.String()).
+		Str("command", entry.Command).
+		Bool("allowed", entry.Allowed).
+		Msg("rcon command")
+
+	if err := bot.settings.AuditRCON(entry); err != nil {
+		log.Error().Err(err).Msg("unable to write rcon audit trail")
+	}
+}
+
+func formatRCONOutput(command, out string) string {
+	out = strings.TrimSpace(strings.ReplaceAll(out, "```", "'''"))
+	if out == "" {
+		return fmt.Sprintf("`%s` returned no output.", command)
+	}
+
+	if len(out) > maxRCONOutputLength {
+		out = out[:maxRCONOutputLength] + "\n[…]"
+	}
+
+	return fmt.Sprintf("```\n%s\n```", out)
+}
+
+func ephemeralResponse(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: content,
+		},
+	}); err != nil {
+		log.Error().Err(err).Msg("unable to respond to command")
+	}
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hldsbot/hlds"
+	"hldsbot/twhl"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog/log"
+)
+
+const maxReportWarnings = 5
+
+// Dropped entries worth telling the requester about, in display order.
+var reportOutcomeLabels = []struct {
+	outcome hlds.EntryOutcome
+	label   string
+}{
+	{hlds.OutcomeDisallowedType, "not usable by a map"},
+	{hlds.OutcomeOutsidePrefix, "outside of the map directory"},
+	{hlds.OutcomeDuplicate, "duplicated"},
+	{hlds.OutcomeCaseCollision, "same name as another file once case is ignored"},
+	{hlds.OutcomeInvalidName, "unreadable name"},
+	{hlds.OutcomeStockCopy, "identical to a Half-Life file"},
+	{hlds.OutcomeStockOverride, "replacing a Half-Life file"},
+}
+
+var limitLabels = map[hlds.LimitKind]string{
+	hlds.LimitModels:    "precached models",
+	hlds.LimitSounds:    "precached sounds",
+	hlds.LimitEdicts:    "edicts",
+	hlds.LimitEntData:   "bytes of entity data",
+	hlds.LimitClipNodes: "clipnodes",
+}
+
+// Lets the requester know why some files were not extracted, why some
+// textures or sounds may be missing and which engine limits the map gets
+// close to, sent before the server starts. The full extraction and limits
+// reports are attached so mappers can fix their maps.
+func archiveReportResponse(s *discordgo.Session, i *discordgo.InteractionCreate, vaultMap twhl.VaultMap) {
+	var (
+		report    = vaultMap.Report
+		hasLimits = slices.ContainsFunc(vaultMap.Maps, func(v string) bool {
+			return len(vaultMap.Limits[v].Issues) > 0
+		})
+	)
+	if !report.HasIssues() && !hasLimits &&
+		len(vaultMap.MissingResources) == 0 && len(vaultMap.MissingTextures) == 0 {
+		return
+	}
+
+	var msg strings.Builder
+	writeList := func(names []string) {
+		start := msg.Len()
+		msg.WriteString("```\n")
+		for _, v := range names {
+			if msg.Len()-start+len(v) > 500 {
+				msg.WriteString("[…]\n")
+				break
+			}
+			msg.WriteString(v + "\n")
+		}
+		msg.WriteString("```")
+	}
+
+	if report.HasIssues() {
+		writeReportSummary(&msg, report)
+	}
+
+	if len(vaultMap.MissingResources) > 0 {
+		msg.WriteString("\nThe map references files that are not in the Vault item, ")
+		msg.WriteString("they will be missing unless they're part of _Half-Life_:\n")
+		writeList(vaultMap.MissingResources)
+	}
+
+	if len(vaultMap.MissingTextures) > 0 {
+		msg.WriteString("\nThe map uses textures that are neither embedded nor in its WADs, ")
+		msg.WriteString("players will be disconnected while connecting:\n")
+		writeList(vaultMap.MissingTextures)
+	}
+
+	if hasLimits {
+		msg.WriteString("\nThe map gets close to engine limits, players may crash:\n")
+		writeLimitIssues(&msg, vaultMap)
+	}
+
+	params := &discordgo.WebhookParams{
+		Content: strings.TrimSpace(msg.String()),
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}
+
+	attach := func(name string, v any) {
+		buf, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Str("name", name).Msg("unable to encode report")
+			return
+		}
+
+		params.Files = append(params.Files, &discordgo.File{
+			Name:        fmt.Sprintf("twhl-vault-%d-%s.json", vaultMap.Item.ID, name),
+			ContentType: "application/json",
+			Reader:      bytes.NewReader(buf),
+		})
+	}
+	attach("report", report)
+	if len(vaultMap.Limits) > 0 {
+		attach("limits", vaultMap.Limits)
+	}
+
+	if _, err := s.FollowupMessageCreate(i.Interaction, true, params); err != nil {
+		log.Error().Err(err).Msg("unable to send extraction report")
+	}
+}
+
+func writeReportSummary(msg *strings.Builder, report hlds.ExtractionReport) {
+	counts := report.Counts()
+
+	var dropped []string
+	for _, v := range reportOutcomeLabels {
+		if n := counts[v.outcome]; n > 0 {
+			dropped = append(dropped, fmt.Sprintf("%d %s", n, v.label))
+		}
+	}
+
+	fmt.Fprintf(msg, "Extracted %d files from the Vault item", counts[hlds.OutcomeKept])
+	if len(dropped) > 0 {
+		fmt.Fprintf(msg, ", ignored: %s", strings.Join(dropped, ", "))
+	}
+	msg.WriteString(".\n")
+
+	for i, v := range report.Warnings {
+		if i == maxReportWarnings {
+			fmt.Fprintf(msg, "- and %d more warnings\n", len(report.Warnings)-i)
+			break
+		}
+		msg.WriteString("- " + v + "\n")
+	}
+	msg.WriteString("See the attached report for details.\n")
+}
+
+func writeLimitIssues(msg *strings.Builder, vaultMap twhl.VaultMap) {
+	for _, mapName := range vaultMap.Maps {
+		for _, v := range vaultMap.Limits[mapName].Issues {
+			line := fmt.Sprintf("%d/%d %s (%s)", v.Used, v.Max, limitLabels[v.Kind], v.Engine)
+			if v.Exceeded {
+				line = "**" + line + "**"
+			}
+			if len(vaultMap.Maps) > 1 {
+				line = fmt.Sprintf("`%s`: %s", mapName, line)
+			}
+			msg.WriteString("- " + line + "\n")
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package bsp reads GoldSrc (Half-Life) v30 BSP files.
+package bsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The only BSP version used by GoldSrc.
+const Version = 30
+
+var InvalidLumpErr = errors.New("invalid lump")
+
+// Returned when the file is not a GoldSrc BSP, eg. Quake (v29) or Source
+// (VBSP) maps.
+type UnsupportedVersionError struct {
+	Version int32
+	Magic   string // "VBSP" for Source maps, empty otherwise
+}
+
+func (err *UnsupportedVersionError) Error() string {
+	if err.Magic != "" {
+		return fmt.Sprintf("unsupported BSP format '%s', only GoldSrc v%d BSPs are supported", err.Magic, Version)
+	}
+
+	return fmt.Sprintf("unsupported BSP version %d, only GoldSrc v%d BSPs are supported", err.Version, Version)
+}
+
+// Lumps in the order they appear in the header.
+const (
+	LumpEntities = iota
+	LumpPlanes
+	LumpTextures
+	LumpVertices
+	LumpVisibility
+	LumpNodes
+	LumpTexInfo
+	LumpFaces
+	LumpLighting
+	LumpClipNodes
+	LumpLeaves
+	LumpMarkSurfaces
+	LumpEdges
+	LumpSurfEdges
+	LumpModels
+	lumpCount
+)
+
+var lumpNames = [lumpCount]string{
+	"entities", "planes", "textures", "vertices", "visibility", "nodes",
+	"texinfo", "faces", "lighting", "clipnodes", "leaves", "marksurfaces",
+	"edges", "surfedges", "models",
+}
+
+type Lump struct {
+	Offset int32
+	Length int32
+}
+
+type header struct {
+	Version int32
+	Lumps   [lumpCount]Lump
+}
+
+type Vec3 [3]float32
+
+type Plane struct {
+	Normal Vec3
+	Dist   float32
+	Type   int32
+}
+
+type Node struct {
+	Plane     uint32
+	Children  [2]int16 // negative values are leaves: -(leaf+1)
+	Mins      [3]int16
+	Maxs      [3]int16
+	FirstFace uint16
+	NumFaces  uint16
+}
+
+type TexInfo struct {
+	S      Vec3
+	SShift float32
+	T      Vec3
+	TShift float32
+	MipTex uint32 // index in BSP.Textures
+	Flags  uint32
+}
+
+type Face struct {
+	Plane          uint16
+	PlaneSide      uint16
+	FirstEdge      uint32 // index in BSP.SurfEdges
+	NumEdges       uint16
+	TexInfo        uint16
+	Styles         [4]uint8
+	LightmapOffset int32
+}
+
+type ClipNode struct {
+	Plane    int32
+	Children [2]int16
+}
+
+type Leaf struct {
+	Contents         int32
+	VisOffset        int32
+	Mins             [3]int16
+	Maxs             [3]int16
+	FirstMarkSurface uint16
+	NumMarkSurfaces  uint16
+	AmbientLevels    [4]uint8
+}
+
+type Edge [2]uint16 // vertex indices
+
+// Model 0 is the world, others are brush entities referenced as "*N".
+type Model struct {
+	Mins      Vec3
+	Maxs      Vec3
+	Origin    Vec3
+	HeadNodes [4]int32
+	VisLeafs  int32
+	FirstFace int32
+	NumFaces  int32
+}
+
+type BSP struct {
+	Lumps [lumpCount]Lump
+
+	Entities     []Entity
+	Planes       []Plane
+	Textures     []Texture
+	Vertices     []Vec3
+	Visibility   []byte
+	Nodes        []Node
+	TexInfo      []TexInfo
+	Faces        []Face
+	Lighting     []byte // RGB
+	ClipNodes    []ClipNode
+	Leaves       []Leaf
+	MarkSurfaces []uint16
+	Edges        []Edge
+	SurfEdges    []int32 // signed edge indices, negative means reversed
+	Models       []Model
+}
+
+func Open(path string) (*BSP, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read BSP: %w", err)
+	}
+
+	return Parse(buf)
+}
+
+func Read(r io.Reader) (*BSP, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read BSP: %w", err)
+	}
+
+	return Parse(buf)
+}
+
+func Parse(buf []byte) (*BSP, error) {
+	if len(buf) >= 4 && string(buf[:4]) == "VBSP" {
+		return nil, &UnsupportedVersionError{Magic: "VBSP"}
+	}
+
+	var h header
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("unable to read BSP header: %w", err)
+	}
+
+	if h.Version != Version {
+		return nil, &UnsupportedVersionError{Version: h.Version}
+	}
+
+	var ret = &BSP{Lumps: h.Lumps}
+
+	lumps := make([][]byte, lumpCount)
+	for i, lump := range h.Lumps {
+		if lump.Offset < 0 || lump.Length < 0 || int64(lump.Offset)+int64(lump.Length) > int64(len(buf)) {
+			return nil, fmt.Errorf("%w: %s lump out of bounds", InvalidLumpErr, lumpNames[i])
+		}
+		lumps[i] = buf[lump.Offset : lump.Offset+lump.Length]
+	}
+
+	var err error
+	if ret.Entities, err = parseEntities(lumps[LumpEntities]); err != nil {
+		return nil, fmt.Errorf("unable to parse entities: %w", err)
+	}
+	if ret.Textures, err = parseTextures(lumps[LumpTextures]); err != nil {
+		return nil, fmt.Errorf("unable to parse textures: %w", err)
+	}
+
+	ret.Visibility = lumps[LumpVisibility]
+	ret.Lighting = lumps[LumpLighting]
+
+	if err := errors.Join(
+		readLump(lumps, LumpPlanes, &ret.Planes),
+		readLump(lumps, LumpVertices, &ret.Vertices),
+		readLump(lumps, LumpNodes, &ret.Nodes),
+		readLump(lumps, LumpTexInfo, &ret.TexInfo),
+		readLump(lumps, LumpFaces, &ret.Faces),
+		readLump(lumps, LumpClipNodes, &ret.ClipNodes),
+		readLump(lumps, LumpLeaves, &ret.Leaves),
+		readLump(lumps, LumpMarkSurfaces, &ret.MarkSurfaces),
+		readLump(lumps, LumpEdges, &ret.Edges),
+		readLump(lumps, LumpSurfEdges, &ret.SurfEdges),
+		readLump(lumps, LumpModels, &ret.Models),
+	); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Reads a lump made of an array of fixed-size structs.
+func readLump[T any](lumps [][]byte, lump int, dst *[]T) error {
+	var (
+		buf  = lumps[lump]
+		zero T
+		size = binary.Size(zero)
+	)
+	if len(buf)%size != 0 {
+		return fmt.Errorf("%w: %s length %d is not a multiple of %d", InvalidLumpErr, lumpNames[lump], len(buf), size)
+	}
+
+	*dst = make([]T, len(buf)/size)
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, *dst); err != nil {
+		return fmt.Errorf("unable to read %s lump: %w", lumpNames[lump], err)
+	}
+
+	return nil
+}
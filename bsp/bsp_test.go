@@ -0,0 +1,127 @@
+package bsp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hldsbot/bsp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Builds a BSP from raw lumps, missing lumps are left empty.
+func buildBSP(t *testing.T, version int32, lumps map[int][]byte) []byte {
+	t.Helper()
+
+	var (
+		headerSize = 4 + 15*8
+		header     bytes.Buffer
+		data       bytes.Buffer
+	)
+	require.NoError(t, binary.Write(&header, binary.LittleEndian, version))
+	for i := 0; i < 15; i++ {
+		lump := lumps[i]
+		require.NoError(t, binary.Write(&header, binary.LittleEndian, [2]int32{
+			int32(headerSize + data.Len()),
+			int32(len(lump)),
+		}))
+		data.Write(lump)
+	}
+
+	return append(header.Bytes(), data.Bytes()...)
+}
+
+func le(t *testing.T, values ...any) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, v := range values {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, v))
+	}
+
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	var (
+		name     = [16]byte{'A', 'A', 'A', 'T', 'R', 'I', 'G', 'G', 'E', 'R'}
+		pixels   = bytes.Repeat([]byte{1}, 16*16+8*8+4*4+2*2)
+		palette  = bytes.Repeat([]byte{0xff, 0, 0}, 256)
+		embedded = le(t, name, uint32(16), uint32(16), [4]uint32{40, 40 + 256, 40 + 256 + 64, 40 + 256 + 64 + 16}, pixels, uint16(256), palette)
+		external = le(t, [16]byte{'c', 'r', 'a', 't', 'e'}, uint32(64), uint32(32), [4]uint32{})
+	)
+
+	buf := buildBSP(t, bsp.Version, map[int][]byte{
+		bsp.LumpEntities: []byte(`{
+"classname" "worldspawn"
+"wad" "\half-life\valve\halflife.wad;mywad.wad"
+"skyname" "desert"
+}
+{
+"classname" "func_door"
+"model" "*1"
+"targetname" "door{1}"
+}
+` + "\x00"),
+		bsp.LumpTextures: le(t, uint32(3), [3]int32{16, 16 + int32(len(embedded)), -1}, embedded, external),
+		bsp.LumpVertices: le(t, [2]bsp.Vec3{{0, 0, 0}, {64, 0, 0}}),
+		bsp.LumpEdges:    le(t, bsp.Edge{0, 1}),
+		bsp.LumpModels:   le(t, make([]byte, 64*2)),
+	})
+
+	parsed, err := bsp.Parse(buf)
+	require.NoError(t, err)
+
+	require.Len(t, parsed.Entities, 2)
+	world, ok := parsed.Worldspawn()
+	require.True(t, ok)
+	require.Equal(t, `\half-life\valve\halflife.wad;mywad.wad`, world["wad"])
+	require.Equal(t, "desert", world["skyname"])
+
+	door, ok := parsed.FindEntity("func_door")
+	require.True(t, ok)
+	require.Equal(t, "door{1}", door["targetname"], "braces in quoted strings are not tokens")
+	model, ok := door.BrushModel()
+	require.True(t, ok)
+	require.Equal(t, 1, model)
+
+	require.Len(t, parsed.Textures, 3)
+	require.Equal(t, "AAATRIGGER", parsed.Textures[0].Name)
+	require.True(t, parsed.Textures[0].Embedded())
+	require.Len(t, parsed.Textures[0].Mips[3], 4)
+	require.Equal(t, palette, parsed.Textures[0].Palette)
+	require.Equal(t, "crate", parsed.Textures[1].Name)
+	require.False(t, parsed.Textures[1].Embedded())
+	require.Equal(t, uint32(64), parsed.Textures[1].Width)
+	require.True(t, parsed.Textures[2].Missing)
+
+	require.Len(t, parsed.Vertices, 2)
+	require.Equal(t, bsp.Vec3{64, 0, 0}, parsed.Vertices[1])
+	require.Len(t, parsed.Edges, 1)
+	require.Len(t, parsed.Models, 2)
+}
+
+func TestParseRejectsOtherVersions(t *testing.T) {
+	var errVersion *bsp.UnsupportedVersionError
+
+	_, err := bsp.Parse(buildBSP(t, 29, nil))
+	require.ErrorAs(t, err, &errVersion)
+	require.Equal(t, int32(29), errVersion.Version)
+
+	_, err = bsp.Parse(append([]byte("VBSP"), make([]byte, 1024)...))
+	require.ErrorAs(t, err, &errVersion)
+	require.Equal(t, "VBSP", errVersion.Magic)
+}
+
+func TestParseInvalidLumps(t *testing.T) {
+	_, err := bsp.Parse(buildBSP(t, bsp.Version, map[int][]byte{bsp.LumpEdges: {1, 2, 3}}))
+	require.ErrorIs(t, err, bsp.InvalidLumpErr)
+
+	buf := buildBSP(t, bsp.Version, nil)
+	binary.LittleEndian.PutUint32(buf[4+bsp.LumpPlanes*8+4:], 1<<20)
+	_, err = bsp.Parse(buf)
+	require.ErrorIs(t, err, bsp.InvalidLumpErr, "lumps must be within the file")
+
+	_, err = bsp.Parse(buildBSP(t, bsp.Version, map[int][]byte{bsp.LumpEntities: []byte(`{ "classname" "worldspawn"`)}))
+	require.ErrorIs(t, err, bsp.InvalidEntitiesErr)
+}
@@ -0,0 +1,165 @@
+package bsp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var InvalidEntitiesErr = errors.New("invalid entity lump")
+
+// Key/value pairs of a single entity. When a key is repeated the last value
+// wins, like the engine does.
+type Entity map[string]string
+
+func (ent Entity) ClassName() string {
+	return ent["classname"]
+}
+
+// Returns the brush model index of entities using "*N" models.
+func (ent Entity) BrushModel() (int, bool) {
+	model, ok := strings.CutPrefix(ent["model"], "*")
+	if !ok {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(model)
+	return i, err == nil && i >= 0
+}
+
+// Parses the "x y z" origin of point entities.
+func (ent Entity) Origin() (Vec3, bool) {
+	var (
+		ret    Vec3
+		fields = strings.Fields(ent["origin"])
+	)
+	if len(fields) != len(ret) {
+		return ret, false
+	}
+
+	for i, v := range fields {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return ret, false
+		}
+		ret[i] = float32(f)
+	}
+
+	return ret, true
+}
+
+// Returns the first entity with the given class name.
+func (bsp *BSP) FindEntity(className string) (Entity, bool) {
+	for _, v := range bsp.Entities {
+		if v.ClassName() == className {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns the worldspawn entity, which should always be the first one.
+func (bsp *BSP) Worldspawn() (Entity, bool) {
+	return bsp.FindEntity("worldspawn")
+}
+
+// The entity lump is text following the same syntax as .map files minus
+// the brushes: { "key" "value" ... } blocks, NUL terminated.
+func parseEntities(buf []byte) ([]Entity, error) {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	var (
+		ret    []Entity
+		tokens = tokenizer{buf: buf}
+	)
+	for {
+		tok, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return ret, nil
+		}
+
+		if tok != "{" {
+			return nil, fmt.Errorf("%w: expected '{' got '%s'", InvalidEntitiesErr, tok)
+		}
+
+		ent, err := parseEntity(&tokens)
+		if err != nil {
+			return nil, fmt.Errorf("entity #%d: %w", len(ret), err)
+		}
+		ret = append(ret, ent)
+	}
+}
+
+func parseEntity(tokens *tokenizer) (Entity, error) {
+	var ent = make(Entity)
+	for {
+		key, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected end of lump", InvalidEntitiesErr)
+		}
+		if key == "}" && !tokens.quoted {
+			return ent, nil
+		}
+
+		value, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || (value == "}" && !tokens.quoted) {
+			return nil, fmt.Errorf("%w: missing value for key '%s'", InvalidEntitiesErr, key)
+		}
+
+		ent[key] = value
+	}
+}
+
+// Same rules as the engine COM_Parse: tokens are separated by whitespace,
+// quoted strings have no escapes, braces are single tokens.
+type tokenizer struct {
+	buf    []byte
+	pos    int
+	quoted bool // whether the last token was quoted
+}
+
+func (t *tokenizer) next() (string, bool, error) {
+	for t.pos < len(t.buf) && t.buf[t.pos] <= ' ' {
+		t.pos++
+	}
+	if t.pos >= len(t.buf) {
+		return "", false, nil
+	}
+
+	t.quoted = false
+	switch c := t.buf[t.pos]; c {
+	case '{', '}':
+		t.pos++
+		return string(c), true, nil
+	case '"':
+		end := bytes.IndexByte(t.buf[t.pos+1:], '"')
+		if end < 0 {
+			return "", false, fmt.Errorf("%w: unterminated string at offset %d", InvalidEntitiesErr, t.pos)
+		}
+		tok := string(t.buf[t.pos+1 : t.pos+1+end])
+		t.pos += end + 2
+		t.quoted = true
+		return tok, true, nil
+	}
+
+	start := t.pos
+	for t.pos < len(t.buf) && t.buf[t.pos] > ' ' && t.buf[t.pos] != '"' && t.buf[t.pos] != '{' && t.buf[t.pos] != '}' {
+		t.pos++
+	}
+
+	return string(t.buf[start:t.pos]), true, nil
+}
@@ -0,0 +1,65 @@
+package bsp
+
+import "fmt"
+
+// Returns the polygon of a face in winding order.
+func (bsp *BSP) FaceVertices(face Face) ([]Vec3, error) {
+	if int(face.FirstEdge)+int(face.NumEdges) > len(bsp.SurfEdges) {
+		return nil, fmt.Errorf("%w: face edges out of bounds", InvalidLumpErr)
+	}
+
+	var ret = make([]Vec3, 0, face.NumEdges)
+	for _, surfEdge := range bsp.SurfEdges[face.FirstEdge : face.FirstEdge+uint32(face.NumEdges)] {
+		var (
+			edge   = int64(surfEdge)
+			vertex int
+		)
+		if edge < 0 {
+			edge = -edge
+		}
+		if edge >= int64(len(bsp.Edges)) {
+			return nil, fmt.Errorf("%w: edge %d out of bounds", InvalidLumpErr, edge)
+		}
+
+		if surfEdge < 0 {
+			vertex = int(bsp.Edges[edge][1])
+		} else {
+			vertex = int(bsp.Edges[edge][0])
+		}
+		if vertex >= len(bsp.Vertices) {
+			return nil, fmt.Errorf("%w: vertex %d out of bounds", InvalidLumpErr, vertex)
+		}
+
+		ret = append(ret, bsp.Vertices[vertex])
+	}
+
+	return ret, nil
+}
+
+// Returns the normal of the face front side.
+func (bsp *BSP) FaceNormal(face Face) (Vec3, error) {
+	if int(face.Plane) >= len(bsp.Planes) {
+		return Vec3{}, fmt.Errorf("%w: plane %d out of bounds", InvalidLumpErr, face.Plane)
+	}
+
+	normal := bsp.Planes[face.Plane].Normal
+	if face.PlaneSide != 0 {
+		normal = Vec3{-normal[0], -normal[1], -normal[2]}
+	}
+
+	return normal, nil
+}
+
+// Returns the texture of a face, false if it can't be resolved.
+func (bsp *BSP) FaceTexture(face Face) (Texture, bool) {
+	if int(face.TexInfo) >= len(bsp.TexInfo) {
+		return Texture{}, false
+	}
+
+	i := bsp.TexInfo[face.TexInfo].MipTex
+	if int64(i) >= int64(len(bsp.Textures)) {
+		return Texture{}, false
+	}
+
+	return bsp.Textures[i], true
+}
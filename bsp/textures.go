@@ -0,0 +1,130 @@
+package bsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var InvalidTextureErr = errors.New("invalid texture")
+
+const mipLevels = 4
+
+// A miptex as found in the BSP texture lump and in WAD3 files. Textures
+// stored in a WAD only have a name and a size in the BSP.
+type Texture struct {
+	Name          string
+	Width, Height uint32
+
+	// Palette indices, the first level is full size and each following level
+	// is half the size of the previous one. Empty for textures not embedded
+	// in the BSP.
+	Mips [mipLevels][]byte
+
+	// 256 RGB colors, empty if Mips is empty.
+	Palette []byte
+
+	// The texture lump can reference missing textures, only the slot exists.
+	Missing bool
+}
+
+func (tex Texture) Embedded() bool {
+	return len(tex.Mips[0]) > 0
+}
+
+type mipTexHeader struct {
+	Name    [16]byte
+	Width   uint32
+	Height  uint32
+	Offsets [mipLevels]uint32
+}
+
+func parseTextures(buf []byte) ([]Texture, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	r := bytes.NewReader(buf)
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("unable to read texture count: %w", err)
+	}
+	if int64(count)*4 > int64(r.Len()) {
+		return nil, fmt.Errorf("%w: texture count %d out of bounds", InvalidLumpErr, count)
+	}
+
+	offsets := make([]int32, count)
+	if err := binary.Read(r, binary.LittleEndian, offsets); err != nil {
+		return nil, fmt.Errorf("unable to read texture offsets: %w", err)
+	}
+
+	var ret = make([]Texture, 0, count)
+	for i, offset := range offsets {
+		if offset < 0 {
+			ret = append(ret, Texture{Missing: true})
+			continue
+		}
+		if int64(offset) >= int64(len(buf)) {
+			return nil, fmt.Errorf("%w: texture #%d out of bounds", InvalidLumpErr, i)
+		}
+
+		tex, err := ParseMipTex(buf[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("texture #%d: %w", i, err)
+		}
+		ret = append(ret, tex)
+	}
+
+	return ret, nil
+}
+
+// Reads a miptex starting at the beginning of buf. Mip levels and the
+// palette are only read if present.
+func ParseMipTex(buf []byte) (Texture, error) {
+	var h mipTexHeader
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return Texture{}, fmt.Errorf("unable to read miptex header: %w", err)
+	}
+
+	name, _, _ := bytes.Cut(h.Name[:], []byte{0})
+	var tex = Texture{
+		Name:   string(name),
+		Width:  h.Width,
+		Height: h.Height,
+	}
+
+	// Textures are at most 4096² in practice, anything larger is garbage
+	// and would overflow the sizes below.
+	if h.Width == 0 || h.Height == 0 || h.Width > 4096 || h.Height > 4096 {
+		return Texture{}, fmt.Errorf("%w: '%s' has invalid size %dx%d", InvalidTextureErr, tex.Name, h.Width, h.Height)
+	}
+
+	if h.Offsets[0] == 0 {
+		return tex, nil
+	}
+
+	var end uint64
+	for level, offset := range h.Offsets {
+		size := uint64(h.Width>>level) * uint64(h.Height>>level)
+		if uint64(offset)+size > uint64(len(buf)) {
+			return Texture{}, fmt.Errorf("%w: '%s' mip level %d out of bounds", InvalidTextureErr, tex.Name, level)
+		}
+
+		tex.Mips[level] = buf[offset : uint64(offset)+size]
+		end = max(end, uint64(offset)+size)
+	}
+
+	// The palette follows the last mip level, prefixed by its color count.
+	if end+2 > uint64(len(buf)) {
+		return Texture{}, fmt.Errorf("%w: '%s' has no palette", InvalidTextureErr, tex.Name)
+	}
+	colors := uint64(binary.LittleEndian.Uint16(buf[end:]))
+	if colors > 256 || end+2+colors*3 > uint64(len(buf)) {
+		return Texture{}, fmt.Errorf("%w: '%s' palette out of bounds", InvalidTextureErr, tex.Name)
+	}
+	tex.Palette = make([]byte, 256*3)
+	copy(tex.Palette, buf[end+2:end+2+colors*3])
+
+	return tex, nil
+}
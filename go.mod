@@ -7,8 +7,11 @@ require (
 	github.com/bwmarrin/discordgo v0.28.1
 	github.com/docker/docker v20.10.27+incompatible
 	github.com/jackpal/gateway v1.0.15
+	github.com/nwaples/rardecode/v2 v2.4.1
 	github.com/rs/zerolog v1.33.0
 	github.com/stretchr/testify v1.9.0
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.15.0
 )
 
 require (
@@ -38,12 +41,10 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.4.1 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	github.com/ulikunitz/xz v0.5.12 // indirect
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.1 // indirect
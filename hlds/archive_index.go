@@ -0,0 +1,249 @@
+package hlds
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nwaples/rardecode/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Tried in order on names that are not valid UTF-8, the decoding with the
+// most latin letters wins. ZIP defaults to CP437 but Windows tools often used the
+// ANSI codepage instead.
+var LegacyNameEncodings = []encoding.Encoding{
+	charmap.CodePage437,
+	charmap.Windows1252,
+}
+
+// Read-only fs.FS over the entries of an archive. Names are decoded here
+// rather than by the archive reader so entries with names we can't make
+// sense of are skipped individually instead of failing the whole archive.
+type indexFS struct {
+	entries map[string]*indexEntry // by cleaned path, including directories
+	skipped []string               // quoted raw names of skipped entries
+}
+
+type indexEntry struct {
+	info     fs.FileInfo
+	open     func() (io.ReadCloser, error) // nil for directories
+	children []string                      // base names, sorted
+}
+
+func newIndexFS() *indexFS {
+	return &indexFS{
+		entries: map[string]*indexEntry{
+			".": {info: dirInfo(".")},
+		},
+	}
+}
+
+// Adds an entry, raw is the name as stored in the archive.
+func (index *indexFS) add(raw string, info fs.FileInfo, open func() (io.ReadCloser, error)) {
+	name, ok := decodeEntryName(raw)
+	if !ok {
+		log.Warn().Str("name", strconv.Quote(raw)).Msg("skipping archive entry with an invalid name")
+		index.skipped = append(index.skipped, strconv.Quote(raw))
+		return
+	}
+	if name == "." {
+		return
+	}
+
+	if info.IsDir() {
+		index.addDir(name)
+		return
+	}
+
+	if _, ok := index.entries[name]; ok {
+		log.Debug().Str("name", name).Msg("skipping duplicate archive entry")
+		return
+	}
+
+	index.addDir(path.Dir(name))
+	index.entries[name] = &indexEntry{
+		info: namedInfo{FileInfo: info, name: path.Base(name)},
+		open: open,
+	}
+	index.addChild(path.Dir(name), path.Base(name))
+}
+
+func (index *indexFS) addDir(name string) {
+	if entry, ok := index.entries[name]; ok {
+		if entry.open != nil {
+			log.Debug().Str("name", name).Msg("archive entry is both a file and a dir")
+		}
+		return
+	}
+
+	index.addDir(path.Dir(name))
+	index.entries[name] = &indexEntry{info: dirInfo(path.Base(name))}
+	index.addChild(path.Dir(name), path.Base(name))
+}
+
+func (index *indexFS) addChild(dir, name string) {
+	parent := index.entries[dir]
+	if i, found := slices.BinarySearch(parent.children, name); !found {
+		parent.children = slices.Insert(parent.children, i, name)
+	}
+}
+
+func (index *indexFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := index.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if entry.open == nil {
+		var children = make([]fs.DirEntry, 0, len(entry.children))
+		for _, v := range entry.children {
+			children = append(children, fs.FileInfoToDirEntry(index.entries[path.Join(name, v)].info))
+		}
+		return &indexDir{info: entry.info, children: children}, nil
+	}
+
+	rc, err := entry.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &indexFile{ReadCloser: rc, info: entry.info}, nil
+}
+
+// Returns a cleaned slash-separated path, or false if the name can't be
+// decoded or escapes the archive. Names are only decoded as legacy
+// codepages when they're not valid UTF-8, some tools write UTF-8 without
+// setting the ZIP flag.
+func decodeEntryName(raw string) (string, bool) {
+	name := raw
+	if !utf8.ValidString(raw) {
+		name = decodeLegacyName(raw)
+	}
+
+	if !utf8.ValidString(name) || strings.ContainsFunc(name, func(r rune) bool {
+		return r == utf8.RuneError || unicode.IsControl(r)
+	}) {
+		return "", false
+	}
+
+	name = path.Clean(strings.TrimLeft(strings.ReplaceAll(name, `\`, "/"), "/"))
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+
+	return name, true
+}
+
+func decodeLegacyName(raw string) string {
+	var (
+		best      string
+		bestScore = -1
+	)
+	for _, enc := range LegacyNameEncodings {
+		name, err := enc.NewDecoder().String(raw)
+		if err != nil {
+			continue
+		}
+
+		var score int
+		for _, r := range name {
+			if unicode.Is(unicode.Latin, r) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+
+	return best
+}
+
+type indexFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *indexFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type indexDir struct {
+	info     fs.FileInfo
+	children []fs.DirEntry
+	offset   int
+}
+
+func (d *indexDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *indexDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *indexDir) Close() error {
+	return nil
+}
+
+func (d *indexDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := d.children[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.children)
+		return left, nil
+	}
+
+	if len(left) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(left))
+	d.offset += n
+	return left[:n], nil
+}
+
+// The archive reader names entries after the raw name, use the decoded one.
+type namedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (info namedInfo) Name() string {
+	return info.name
+}
+
+// Archives don't always have entries for directories, they're synthesized.
+type dirInfo string
+
+func (name dirInfo) Name() string       { return string(name) }
+func (name dirInfo) Size() int64        { return 0 }
+func (name dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (name dirInfo) ModTime() time.Time { return time.Time{} }
+func (name dirInfo) IsDir() bool        { return true }
+func (name dirInfo) Sys() any           { return nil }
+
+// rardecode only exposes the fs.FileInfo of its entries through its own
+// fs.FS, which rejects the whole archive on a single invalid name.
+type rarFileInfo struct {
+	h *rardecode.FileHeader
+}
+
+func (info rarFileInfo) Name() string       { return path.Base(info.h.Name) }
+func (info rarFileInfo) Size() int64        { return info.h.UnPackedSize }
+func (info rarFileInfo) Mode() fs.FileMode  { return info.h.Mode() }
+func (info rarFileInfo) ModTime() time.Time { return info.h.ModificationTime }
+func (info rarFileInfo) IsDir() bool        { return info.h.IsDir }
+func (info rarFileInfo) Sys() any           { return nil }
@@ -0,0 +1,150 @@
+package hlds
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Resources an archive is allowed to use once extracted. Sizes are in bytes.
+type ArchiveLimits struct {
+	MaxTotalSize        int64
+	MaxFileSize         int64
+	MaxFiles            int
+	MaxPathDepth        int
+	MaxCompressionRatio int64 // total uncompressed size over archive size
+}
+
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxTotalSize:        512 << 20,
+	MaxFileSize:         256 << 20,
+	MaxFiles:            10_000,
+	MaxPathDepth:        16,
+	MaxCompressionRatio: 100,
+}
+
+// Returned when an archive goes over one of its ArchiveLimits.
+type LimitError struct {
+	Limit string // name of the ArchiveLimits field
+	Value int64
+	Max   int64
+	Path  string // offending file, empty if the limit applies to the whole archive
+}
+
+func (err *LimitError) Error() string {
+	if err.Path != "" {
+		return fmt.Sprintf("archive file '%s' is over %s: %d > %d", err.Path, err.Limit, err.Value, err.Max)
+	}
+
+	return fmt.Sprintf("archive is over %s: %d > %d", err.Limit, err.Value, err.Max)
+}
+
+// Checked for every entry in the archive while walking it.
+func (limits ArchiveLimits) checkEntry(path string, count int) error {
+	if limits.MaxFiles > 0 && count > limits.MaxFiles {
+		return &LimitError{Limit: "MaxFiles", Value: int64(count), Max: int64(limits.MaxFiles)}
+	}
+
+	if depth := strings.Count(path, "/") + 1; limits.MaxPathDepth > 0 && depth > limits.MaxPathDepth {
+		return &LimitError{Limit: "MaxPathDepth", Value: int64(depth), Max: int64(limits.MaxPathDepth), Path: path}
+	}
+
+	return nil
+}
+
+// Checks the sizes announced by the archive headers of the files we're
+// going to extract.
+func (limits ArchiveLimits) checkSizes(archive fs.FS, archiveSize int64, srcNames []string) error {
+	var total int64
+	for _, name := range srcNames {
+		info, err := fs.Stat(archive, name)
+		if err != nil {
+			return fmt.Errorf("unable to stat '%s' in archive: %w", name, err)
+		}
+
+		if err := limits.checkFileSize(name, info.Size()); err != nil {
+			return err
+		}
+
+		total += info.Size()
+		if err := limits.checkTotalSize(archiveSize, total); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (limits ArchiveLimits) checkFileSize(path string, size int64) error {
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		return &LimitError{Limit: "MaxFileSize", Value: size, Max: limits.MaxFileSize, Path: path}
+	}
+
+	return nil
+}
+
+func (limits ArchiveLimits) checkTotalSize(archiveSize, total int64) error {
+	if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+		return &LimitError{Limit: "MaxTotalSize", Value: total, Max: limits.MaxTotalSize}
+	}
+
+	if limits.MaxCompressionRatio > 0 && archiveSize > 0 && total/archiveSize > limits.MaxCompressionRatio {
+		return &LimitError{Limit: "MaxCompressionRatio", Value: total / archiveSize, Max: limits.MaxCompressionRatio}
+	}
+
+	return nil
+}
+
+// Copies at most the bytes allowed for a single file given what was
+// already extracted, headers can lie about sizes.
+func (limits ArchiveLimits) copy(dst io.Writer, src io.Reader, path string, archiveSize, extracted int64) (int64, error) {
+	var max int64 = -1
+	if limits.MaxFileSize > 0 {
+		max = limits.MaxFileSize
+	}
+	if limits.MaxTotalSize > 0 && (max < 0 || limits.MaxTotalSize-extracted < max) {
+		max = limits.MaxTotalSize - extracted
+	}
+	if limits.MaxCompressionRatio > 0 && archiveSize > 0 {
+		if left := (limits.MaxCompressionRatio+1)*archiveSize - 1 - extracted; max < 0 || left < max {
+			max = left
+		}
+	}
+
+	if max < 0 {
+		return io.Copy(dst, src)
+	}
+
+	// Read one more byte than allowed to know if we went over.
+	written, err := io.Copy(dst, io.LimitReader(src, max+1))
+	if err != nil {
+		return written, err
+	}
+
+	if written > max {
+		if err := limits.checkFileSize(path, written); err != nil {
+			return written, err
+		}
+
+		return written, limits.checkTotalSize(archiveSize, extracted+written)
+	}
+
+	return written, nil
+}
+
+// Decompressed tar streams hold the files plus their headers and padding,
+// twice the size limits are allowed for them. Entries are checked against
+// the actual limits once indexed.
+func (limits ArchiveLimits) spool(dst io.Writer, src io.Reader, archiveSize int64) error {
+	var stream = ArchiveLimits{
+		MaxTotalSize:        2 * limits.MaxTotalSize,
+		MaxCompressionRatio: 2 * limits.MaxCompressionRatio,
+	}
+
+	if _, err := stream.copy(dst, src, "", archiveSize, 0); err != nil {
+		return fmt.Errorf("unable to decompress archive: %w", err)
+	}
+
+	return nil
+}
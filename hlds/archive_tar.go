@@ -0,0 +1,126 @@
+package hlds
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/ulikunitz/xz"
+)
+
+// Opens a tar archive, compressed or not. Tar has no index so we build our
+// own, compressed streams are first decompressed to an unlinked temporary
+// file so entries can be read in any order.
+func openTarFS(path string, typ fsType, limits ArchiveLimits, archiveSize int64) (fs.FS, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open archive: %w", err)
+	}
+
+	if typ != fsTypeTar {
+		spooled, err := spoolTarStream(f, typ, limits, archiveSize)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		f = spooled
+	}
+
+	index, err := indexTar(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return index, f.Close, nil
+}
+
+func spoolTarStream(src io.Reader, typ fsType, limits ArchiveLimits, archiveSize int64) (*os.File, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch typ {
+	case fsTypeTarGzip:
+		r, err = gzip.NewReader(src)
+	case fsTypeTarBzip2:
+		r = bzip2.NewReader(src)
+	case fsTypeTarXZ:
+		r, err = xz.NewReader(src)
+	default:
+		return nil, UnknownArchiveErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to init decompression: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "hldsbot-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file: %w", err)
+	}
+	// Still usable until closed.
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to unlink temp file: %w", err)
+	}
+
+	if err := limits.spool(f, r, archiveSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to rewind temp file: %w", err)
+	}
+
+	return f, nil
+}
+
+func indexTar(f *os.File) (*indexFS, error) {
+	var (
+		index   = newIndexFS()
+		counter = &countingReader{r: f}
+		tr      = tar.NewReader(counter)
+	)
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return index, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tar header: %w", err)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			index.add(h.Name, h.FileInfo(), nil)
+		case tar.TypeReg:
+			// Next stops right before the entry data.
+			offset, size := counter.n, h.Size
+			index.add(h.Name, h.FileInfo(), func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(f, offset, size)), nil
+			})
+		default:
+			log.Debug().Str("name", h.Name).Msg("skipping tar entry that is not a regular file")
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,161 @@
+package hlds
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// A named set of cvars. When multiple layers are used, they're applied in
+// order and later layers override earlier ones.
+type CVarLayer struct {
+	Name  string
+	CVars CVars
+}
+
+type ResolvedCVar struct {
+	Value string
+	Layer string // name of the layer the value comes from
+}
+
+// CVars set by HLDSBot itself on each server, they are always applied last
+// and cannot be overridden.
+var generatedCVars = []string{
+	"hostname",
+	"mp_timeleft",
+	"rcon_password",
+	"sv_allowdownload",
+	"sv_allowupload",
+	"sv_downloadurl",
+	"sv_password",
+}
+
+func IsGeneratedCVar(key string) bool {
+	return slices.Contains(generatedCVars, strings.ToLower(key))
+}
+
+// Cvars users may set, each cvar is written as a line of instance.cfg so
+// anything else could run a command or point the server to other files.
+// Game and server cvars are allowed by prefix, generated cvars are allowed
+// here but cannot be overridden, see IsGeneratedCVar.
+var (
+	allowedCVarPrefixes = []string{"mp_", "sv_"}
+	allowedCVars        = []string{
+		"allow_spectators", "decalfrequency", "edgefriction", "pausable",
+		"sys_ticrate",
+	}
+)
+
+func isAllowedCVar(key string) bool {
+	key = strings.ToLower(key)
+	if slices.Contains(allowedCVars, key) || slices.Contains(generatedCVars, key) {
+		return true
+	}
+
+	return slices.ContainsFunc(allowedCVarPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(key, prefix) && len(key) > len(prefix)
+	})
+}
+
+// Only names made of letters, digits and underscores are accepted, and
+// unknown cvars and commands are rejected, see allowedCVars.
+func ValidateCVar(key, value string) error {
+	if key == "" || strings.IndexFunc(key, func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) >= 0 {
+		return fmt.Errorf("invalid cvar name: '%s'", key)
+	}
+
+	if !isAllowedCVar(key) {
+		return fmt.Errorf("'%s' is not a known cvar or cannot be set", key)
+	}
+
+	if !isStringValidCVar(value) {
+		return fmt.Errorf("invalid value in cvar '%s': '%s'", key, value)
+	}
+
+	return nil
+}
+
+// Returns the effective value of each cvar and the layer it comes from.
+func ResolveCVars(layers []CVarLayer) map[string]ResolvedCVar {
+	var ret = make(map[string]ResolvedCVar)
+
+	for _, layer := range layers {
+		for k, v := range layer.CVars {
+			ret[k] = ResolvedCVar{Value: v, Layer: layer.Name}
+		}
+	}
+
+	return ret
+}
+
+// Reads "key value" pairs from a GoldSrc .cfg file. Comments and the echo and
+// exec commands are ignored.
+func ParseCVars(r io.Reader) (CVars, error) {
+	var (
+		ret     = NewCVars()
+		scanner = bufio.NewScanner(r)
+		lineNum int
+	)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		key = strings.Trim(key, `"`)
+		switch key {
+		case "echo", "exec":
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, "//"); i >= 0 && !strings.HasPrefix(value, `"`) {
+			value = strings.TrimSpace(value[:i])
+		}
+		value = strings.Trim(value, `"`)
+
+		if err := ValidateCVar(key, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		ret[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read cfg: %w", err)
+	}
+
+	return ret, nil
+}
+
+func writeCVarLayer(w io.Writer, name string, cvars CVars) error {
+	if len(cvars) == 0 {
+		return nil
+	}
+
+	if strings.ContainsAny(name, "\r\n") {
+		return errors.New("invalid layer name")
+	}
+
+	if _, err := fmt.Fprintf(w, "// {{{ %s\n", name); err != nil {
+		return fmt.Errorf("unable to write layer header: %w", err)
+	}
+
+	if err := cvars.Write(w); err != nil {
+		return fmt.Errorf("unable to write layer '%s': %w", name, err)
+	}
+
+	if _, err := fmt.Fprintln(w, "// }}}"); err != nil {
+		return fmt.Errorf("unable to write layer footer: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package hlds_test
+
+import (
+	"hldsbot/hlds"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCVars(t *testing.T) {
+	cvars, err := hlds.ParseCVars(strings.NewReader(`
+# {{{ Vendor defaults.
+sv_allow_autoaim 0
+// comment
+mp_timelimit 15 // trailing comment
+hostname "My server"
+echo "Loading instance configuration."
+exec instance.cfg
+`))
+	require.NoError(t, err)
+	require.Equal(t, hlds.CVars{
+		"sv_allow_autoaim": "0",
+		"mp_timelimit":     "15",
+		"hostname":         "My server",
+	}, cvars)
+
+	_, err = hlds.ParseCVars(strings.NewReader(`hostname "foo"bar"`))
+	require.Error(t, err, "quotes within values are rejected")
+}
+
+func TestResolveCVars(t *testing.T) {
+	resolved := hlds.ResolveCVars([]hlds.CVarLayer{
+		{Name: "a", CVars: hlds.CVars{"mp_fraglimit": "25", "mp_timelimit": "15"}},
+		{Name: "b", CVars: hlds.CVars{"mp_fraglimit": "50"}},
+	})
+
+	require.Equal(t, map[string]hlds.ResolvedCVar{
+		"mp_fraglimit": {Value: "50", Layer: "b"},
+		"mp_timelimit": {Value: "15", Layer: "a"},
+	}, resolved)
+}
+
+func TestServerConfigLayers(t *testing.T) {
+	cfg, err := hlds.NewServerConfig(time.Hour, "", 2, []string{"crossfire"}, []hlds.CVarLayer{
+		{Name: "image defaults", CVars: hlds.CVars{"mp_fraglimit": "25"}},
+		{Name: "preset", CVars: hlds.CVars{"mp_fraglimit": "50"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "50", cfg.CVar("mp_fraglimit"))
+
+	var buf strings.Builder
+	require.NoError(t, cfg.WriteInstanceConfig(&buf))
+	require.Less(t,
+		strings.Index(buf.String(), `"mp_fraglimit" "25"`),
+		strings.Index(buf.String(), `"mp_fraglimit" "50"`),
+		"later layers are written last",
+	)
+
+	_, err = hlds.NewServerConfig(time.Hour, "", 2, []string{"crossfire"}, []hlds.CVarLayer{
+		{Name: "guild", CVars: hlds.CVars{"rcon_password": "hunter2"}},
+	})
+	require.Error(t, err, "generated cvars cannot be overridden")
+}
+
+func TestValidateCVar(t *testing.T) {
+	require.NoError(t, hlds.ValidateCVar("mp_fraglimit", "25"))
+	require.NoError(t, hlds.ValidateCVar("hostname", "My server"))
+
+	require.NoError(t, hlds.ValidateCVar("SV_Cheats", "0"))
+	require.NoError(t, hlds.ValidateCVar("pausable", "0"))
+
+	for _, key := range []string{
+		"quit", "exec", "EXEC", "alias", "logaddress_add", "mp_fraglimit;quit", "sv_cheats\n", "",
+		"plugin_unload", "mapchangecfgfile", "lservercfgfile", "motdfile", "rcon", "amx_cvar",
+		"meta", "mp_", "sv_", "log",
+	} {
+		require.Error(t, hlds.ValidateCVar(key, "1"), key)
+	}
+	require.Error(t, hlds.ValidateCVar("hostname", `a";quit`), "quotes within values are rejected")
+}
@@ -0,0 +1,188 @@
+package hlds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Finished demos are moved there once their server is removed and kept
+	// for DemoRetention. Being under UserContentDir, they'll be served over
+	// HTTP along with the fastdl content.
+	DemosDir      = UserContentDir + "/demos"
+	DemoRetention = 7 * 24 * time.Hour
+
+	hltvDemosMountDest = "/home/steam/hlds/valve/hldsbot_demos"
+
+	// HLTV appends the date and map name to this when it starts recording,
+	// and starts a new file on each changelevel.
+	hltvDemoPrefix = "hldsbot_demos/session"
+)
+
+// What's left of a server once it's been removed from the pool.
+type ClosedServer struct {
+	ID       ServerID
+	MapCycle []string
+
+	// Download URLs of the demos recorded during the session, if any.
+	DemoURLs      []string
+	DemosExpireAt time.Time
+}
+
+// Will be called every time a server is removed from the pool, from the
+// goroutine that removed it.
+func (pool *Pool) SetRemoveHandler(f func(ClosedServer)) {
+	pool.onRemove = f
+}
+
+// Creates a dir under parent HLTV can write demos to.
+func makeRecordingDir(parent string) (string, error) {
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create dir '%s': %w", parent, err)
+	}
+
+	dir, err := os.MkdirTemp(parent, "recording-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create recording dir: %w", err)
+	}
+
+	// It's bind-mounted in the HLTV container which runs as containerUID,
+	// MkdirTemp is 0700 and owned by us. Only root can give it away, it's
+	// not made world-writable as demos are served over HTTP.
+	if os.Getuid() != containerUID {
+		if err := os.Chown(dir, containerUID, containerUID); err != nil {
+			if err := os.Remove(dir); err != nil {
+				log.Error().Err(err).Str("path", dir).Msg("unable to remove recording dir")
+			}
+			return "", fmt.Errorf("unable to give recording dir to UID %d, run HLDSBot as that user: %w", containerUID, err)
+		}
+	}
+
+	return dir, nil
+}
+
+func recordingMount(dir string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeBind,
+		Source: dir,
+		Target: hltvDemosMountDest,
+	}
+}
+
+// Sends a command to the HLTV console, HLTV has no rcon so we're writing to
+// its stdin.
+func (pool *Pool) hltvCommand(ctx context.Context, server Server, command string) error {
+	res, err := pool.docker.ContainerAttach(ctx, server.hltvID.String(), types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to attach to HLTV container: %w", err)
+	}
+	defer res.Close()
+
+	if _, err := fmt.Fprintln(res.Conn, command); err != nil {
+		return fmt.Errorf("unable to write to HLTV console: %w", err)
+	}
+
+	return nil
+}
+
+// Lets HLTV finish writing the current demo, an unfinished demo can't be
+// played back.
+func (pool *Pool) stopRecording(ctx context.Context, server Server) {
+	log.Info().Str("id", server.id.String()).Msg("Stopping demo recording.")
+	if err := pool.hltvCommand(ctx, server, "stoprecording"); err != nil {
+		log.Error().Err(err).Msg("unable to stop recording")
+		return
+	}
+
+	// There's no acknowledgment, give it a bit of time to flush.
+	select {
+	case <-time.After(2 * time.Second):
+	case <-ctx.Done():
+	}
+}
+
+// Moves finished demos from a server recording dir to a new dir under
+// DemosDir and returns their download URLs.
+func (pool *Pool) publishDemos(recordingDir string) ([]string, time.Time, error) {
+	demos, err := filepath.Glob(filepath.Join(recordingDir, "*.dem"))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to list demos: %w", err)
+	}
+	if len(demos) == 0 {
+		return nil, time.Time{}, nil
+	}
+
+	if err := os.MkdirAll(DemosDir, 0o755); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to create dir '%s': %w", DemosDir, err)
+	}
+	dstDir, err := os.MkdirTemp(DemosDir, "")
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to create demos dir: %w", err)
+	}
+	// Served over HTTP, MkdirTemp is 0700.
+	if err := os.Chmod(dstDir, 0o755); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to set demos dir permissions: %w", err)
+	}
+
+	var (
+		urls = make([]string, 0, len(demos))
+		errs []error
+	)
+	for _, src := range demos {
+		dst := filepath.Join(dstDir, filepath.Base(src))
+		if err := os.Rename(src, dst); err != nil {
+			errs = append(errs, fmt.Errorf("unable to move demo: %w", err))
+			continue
+		}
+
+		urls = append(urls, pool.baseDownloadURL+strings.TrimPrefix(dst, UserContentDir))
+	}
+
+	return urls, time.Now().Add(DemoRetention), errors.Join(errs...)
+}
+
+func (pool *Pool) removeExpiredDemos() error {
+	entries, err := os.ReadDir(DemosDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to list demos: %w", err)
+	}
+
+	var (
+		errs      []error
+		threshold = time.Now().Add(-DemoRetention)
+	)
+	for _, v := range entries {
+		info, err := v.Info()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if info.ModTime().After(threshold) {
+			continue
+		}
+
+		path := filepath.Join(DemosDir, v.Name())
+		log.Info().Str("path", path).Msg("Removing expired demos.")
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove expired demos: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
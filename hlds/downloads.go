@@ -0,0 +1,115 @@
+package hlds
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Where the content of running servers is served from, see sv_downloadurl.
+// Must be on the same filesystem as the content store, published trees are
+// hardlinks to its objects.
+const DownloadsDir = UserContentDir + "/downloads"
+
+// Publishes a copy of addonsDir under DownloadsDir and returns its path.
+// Trees are named after the content they link to so servers running the same
+// content, even extracted again or from the Vault cache, share the same
+// sv_downloadurl and clients or proxies can reuse what they downloaded.
+func (pool *Pool) publishDownloads(addonsDir string) (string, error) {
+	key, err := pool.store.Key(addonsDir)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(DownloadsDir, key)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(DownloadsDir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create downloads dir: %w", err)
+	}
+
+	// Build aside and move in place once complete.
+	tmpDir, err := os.MkdirTemp(DownloadsDir, ".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temp dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Error().Err(err).Msg("unable to remove temporary downloads dir")
+		}
+	}()
+
+	// Served over HTTP, MkdirTemp is 0700.
+	if err := os.Chmod(tmpDir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to make downloads dir readable: %w", err)
+	}
+
+	if err := LinkTree(addonsDir, tmpDir); err != nil {
+		return "", fmt.Errorf("unable to publish downloads: %w", err)
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return "", fmt.Errorf("unable to publish downloads: %w", err)
+	}
+
+	return dir, nil
+}
+
+// Removes the published tree unless another server uses it.
+func (pool *Pool) releaseDownloads(dir string, id ServerID) error {
+	if dir == "" || !strings.HasPrefix(dir, DownloadsDir+"/") {
+		return nil
+	}
+
+	// Held until the dir is gone so a server using it can't be added
+	// meanwhile.
+	pool.serversMutex.Lock()
+	defer pool.serversMutex.Unlock()
+
+	for _, v := range pool.servers {
+		if v.id != id && v.downloadsDir == dir {
+			return nil
+		}
+	}
+
+	log.Debug().Str("path", dir).Msg("removing dir")
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to remove downloads dir: %w", err)
+	}
+
+	return nil
+}
+
+func (pool *Pool) downloadURL(dir string) string {
+	return pool.baseDownloadURL + strings.TrimPrefix(dir, UserContentDir)
+}
+
+// Recreates the src hierarchy in dst using hardlinks.
+func LinkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case entry.Type().IsRegular():
+			return os.Link(path, target)
+		}
+
+		return nil
+	})
+}
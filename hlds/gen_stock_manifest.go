@@ -0,0 +1,109 @@
+//go:build ignore
+
+// Writes the stock manifest embedded by stock.go from a valve/ directory,
+// only files of the kinds a map archive can extract are listed. With
+// -textures, lists the textures of the stock WADs instead.
+// Usage: go run gen_stock_manifest.go [-textures] /path/to/valve > stock_manifest.txt
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"hldsbot/hlds"
+	"hldsbot/wad"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var extensions = []string{".bmp", ".bsp", ".cfg", ".mdl", ".spr", ".tga", ".txt", ".wad", ".wav"}
+
+func main() {
+	textures := flag.Bool("textures", false, "list the textures of the stock WADs")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: gen_stock_manifest.go [-textures] VALVE_DIR")
+	}
+	root := flag.Arg(0)
+
+	if *textures {
+		printTextures(root)
+		return
+	}
+
+	var lines []string
+	if err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if !slices.Contains(extensions, strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s", sum, filepath.ToSlash(rel)))
+
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+	slices.SortFunc(lines, func(a, b string) int {
+		return strings.Compare(a[sha256.Size*2:], b[sha256.Size*2:])
+	})
+
+	fmt.Println("# Stock files of valve/ map archives must not override, generated by")
+	fmt.Println("# `make stock-manifest`. Do not edit.")
+	for _, v := range lines {
+		fmt.Println(v)
+	}
+}
+
+func printTextures(root string) {
+	var lines []string
+	for _, name := range hlds.StockWADs {
+		w, err := wad.Open(filepath.Join(root, name))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, v := range w.TextureNames() {
+			lines = append(lines, name+" "+strings.ToLower(v))
+		}
+	}
+	slices.Sort(lines)
+	lines = slices.Compact(lines)
+
+	fmt.Println("# Textures of the stock WADs, generated by `make stock-manifest`.")
+	fmt.Println("# Do not edit.")
+	for _, v := range lines {
+		fmt.Println(v)
+	}
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
@@ -1,6 +1,14 @@
 package hlds
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -52,3 +60,159 @@ func TestArchivePathTrimPrefix(t *testing.T) {
 		)
 	}
 }
+
+func TestIsValidMapName(t *testing.T) {
+	cases := map[string]bool{
+		"":                false,
+		"dm_foo":          true,
+		"DM-Foo_v2":       true,
+		"foo;quit":        false,
+		"foo bar":         false,
+		"../foo":          false,
+		"crossfire(beta)": true,
+	}
+
+	for name, expected := range cases {
+		require.Equal(t, expected, isValidMapName(name), "map name: %q", name)
+	}
+}
+
+func TestMergeAddonDir(t *testing.T) {
+	var (
+		src = t.TempDir()
+		dst = t.TempDir()
+	)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "maps"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "maps/new.bsp"), []byte("new"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "shared.wad"), []byte("new"), 0o644))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dst, "maps"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "maps/old.bsp"), []byte("old"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "shared.wad"), []byte("old"), 0o644))
+
+	require.NoError(t, mergeAddonDir(src, dst))
+
+	for path, expected := range map[string]string{
+		"maps/new.bsp": "new",
+		"maps/old.bsp": "old",
+		"shared.wad":   "new",
+	} {
+		actual, err := os.ReadFile(filepath.Join(dst, path))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(actual), path)
+	}
+}
+
+// Vault content must never be able to ship or override server binaries and
+// plugins, see PluginBundle.
+func TestIsMappingDestValidRejectsBinaries(t *testing.T) {
+	for _, v := range []string{
+		"addons/metamod/plugins.ini",
+		"addons/metamod/dlls/metamod.so",
+		"addons/amxmodx/configs/plugins.ini",
+		"dlls/hl.so",
+		"DLLS/hl.so",
+		"cl_dlls/client.dll",
+		"liblist.gam",
+		"maps/../addons/metamod/plugins.ini",
+		"server.cfg",
+	} {
+		require.False(t, isMappingDestValid(v), v)
+	}
+}
+
+func TestMatchTracker(t *testing.T) {
+	tracker := newMatchTracker()
+	for _, v := range []string{
+		`L 10/18/2026 - 12:00:00: "alice<2><STEAM_0:0:1><>" killed "bob<3><STEAM_0:0:2><>" with "crossbow"`,
+		`L 10/18/2026 - 12:01:00: Log file closed`,
+	} {
+		require.False(t, tracker.feed(v), "warmup is ignored")
+	}
+
+	tracker.startRestart()
+	for _, v := range []string{
+		`L 10/18/2026 - 12:02:00: Log file started (file "logs/L1018001.log") (game "valve") (version "48/1.1.2.2/Stdio/8684")`,
+		`L 10/18/2026 - 12:02:00: Started map "stalkyard" (CRC "-1234")`,
+		`L 10/18/2026 - 12:02:01: "alice<2><STEAM_0:0:1><>" entered the game`,
+		`L 10/18/2026 - 12:02:01: "bob<3><STEAM_0:0:2><>" entered the game`,
+		`L 10/18/2026 - 12:02:02: "carol<4><STEAM_0:0:3><>" entered the game`,
+		`L 10/18/2026 - 12:02:10: "bob<3><STEAM_0:0:2><>" killed "alice<2><STEAM_0:0:1><>" with "357"`,
+		`L 10/18/2026 - 12:02:20: "bob<3><STEAM_0:0:2><>" killed "alice<2><STEAM_0:0:1><>" with "gauss"`,
+		`L 10/18/2026 - 12:02:30: "alice<2><STEAM_0:0:1><>" committed suicide with "worldspawn"`,
+		`L 10/18/2026 - 12:02:40: "bob<3><STEAM_0:0:2><>" changed name to "b<o>b"`,
+		`L 10/18/2026 - 12:02:50: "b<o>b<3><STEAM_0:0:2><>" killed "b<o>b<3><STEAM_0:0:2><>" with "satchel"`,
+		`not a log line`,
+	} {
+		require.False(t, tracker.feed(v), v)
+	}
+	require.True(t, tracker.feed(`L 10/18/2026 - 12:12:00: Log file closed`))
+
+	board := tracker.scoreboard()
+	require.Equal(t, "stalkyard", board.Map)
+	require.Equal(t, []PlayerScore{
+		{Name: "b<o>b", Frags: 1, Deaths: 1},
+		{Name: "carol", Frags: 0, Deaths: 0},
+		{Name: "alice", Frags: -1, Deaths: 3},
+	}, board.Players)
+	require.Equal(t, []PlayerScore{{Name: "b<o>b", Frags: 1, Deaths: 1}}, board.Winners())
+}
+
+func TestArchiveLimitsCopy(t *testing.T) {
+	var (
+		limits = ArchiveLimits{MaxFileSize: 10, MaxTotalSize: 15}
+		dst    bytes.Buffer
+	)
+
+	// Headers announced less than what's actually there.
+	_, err := limits.copy(&dst, strings.NewReader("0123456789abcdef"), "lying.bsp", 0, 0)
+	var errLimit *LimitError
+	require.ErrorAs(t, err, &errLimit)
+	require.Equal(t, "MaxFileSize", errLimit.Limit)
+
+	_, err = limits.copy(&dst, strings.NewReader("012345"), "a.wav", 0, 10)
+	require.ErrorAs(t, err, &errLimit)
+	require.Equal(t, "MaxTotalSize", errLimit.Limit)
+
+	written, err := limits.copy(&dst, strings.NewReader("01234"), "b.wav", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), written)
+}
+
+func TestMakeRecordingDir(t *testing.T) {
+	dir, err := makeRecordingDir(filepath.Join(t.TempDir(), "content"))
+	if os.Getuid() != 0 && os.Getuid() != containerUID {
+		require.Error(t, err, "the dir can't be given to the HLTV container user")
+		return
+	}
+	require.NoError(t, err)
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	require.True(t, ok)
+
+	require.Equal(t, uint32(containerUID), stat.Uid, "the HLTV container user must be able to write demos")
+	require.Zero(t, info.Mode().Perm()&0o002, "recording dirs must not be world-writable, mode %s", info.Mode())
+}
+
+// There's no free software able to compress RAR archives, this one was made
+// using RAR and comes from the testdata of github.com/mholt/archiver v3.5.1
+// (MIT). It has no map, only LZ compressed files.
+func TestCompressedRAR(t *testing.T) {
+	fsys, closer, err := archiveFSFactory("testdata/compressed.rar", DefaultArchiveLimits, 0)
+	require.NoError(t, err)
+	defer closer()
+	require.IsType(t, &indexFS{}, fsys)
+
+	for name, expected := range map[string]string{
+		"testdata/already-compressed.jpg": "94cb58cedc0982747010b9a596f6b8d6c30216716f5801c524bf41178d40d10a",
+		"testdata/quote1.txt":             "b0557b43ceeacacd0a1497890402edbae06adc13d3b46a887af4298b6d6a59da",
+	} {
+		buf, err := fs.ReadFile(fsys, name)
+		require.NoError(t, err, name)
+		sum := sha256.Sum256(buf)
+		require.Equal(t, expected, hex.EncodeToString(sum[:]), name)
+	}
+}
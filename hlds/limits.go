@@ -0,0 +1,133 @@
+package hlds
+
+import (
+	"hldsbot/bsp"
+	"path"
+	"strings"
+)
+
+// Something the engine can only hold so much of, exceeding it crashes the
+// server or the clients, often with a confusing message.
+type LimitKind string
+
+const (
+	// Model precache: the world, its brush models, studio models and sprites.
+	LimitModels LimitKind = "models"
+	// Sound precache.
+	LimitSounds LimitKind = "sounds"
+	// Map entities plus one per player slot.
+	LimitEdicts LimitKind = "edicts"
+	// Size of the entity lump in bytes.
+	LimitEntData LimitKind = "entdata"
+	// Hull nodes, indexed using signed 16-bit integers.
+	LimitClipNodes LimitKind = "clipnodes"
+)
+
+var limitKinds = []LimitKind{LimitModels, LimitSounds, LimitEdicts, LimitEntData, LimitClipNodes}
+
+// A set of limits the engine enforces, named after where they come from.
+type EngineLimits struct {
+	Name   string
+	Limits map[LimitKind]int
+}
+
+var KnownEngineLimits = []EngineLimits{
+	{
+		// Hard limits of the engine and of the BSP format.
+		Name: "GoldSrc",
+		Limits: map[LimitKind]int{
+			LimitModels:    512,
+			LimitSounds:    512,
+			LimitClipNodes: 32767,
+		},
+	},
+	{
+		// What clients run with when no launch options are given, the
+		// entity lump size is the one of the SDK compile tools.
+		Name: "GoldSrc defaults",
+		Limits: map[LimitKind]int{
+			LimitEdicts:  900,
+			LimitEntData: 128 << 10,
+		},
+	},
+}
+
+// The game precaches its own models and sounds and spawns entities while
+// playing, usage above this ratio of a limit is reported.
+const limitWarningRatio = 0.8
+
+type LimitUsage struct {
+	Kind LimitKind `json:"kind"`
+	Used int       `json:"used"`
+}
+
+type LimitIssue struct {
+	Kind     LimitKind `json:"kind"`
+	Engine   string    `json:"engine"`
+	Used     int       `json:"used"`
+	Max      int       `json:"max"`
+	Exceeded bool      `json:"exceeded"` // false when only close to the limit
+}
+
+type LimitsReport struct {
+	Usage  []LimitUsage `json:"usage"`
+	Issues []LimitIssue `json:"issues,omitempty"`
+}
+
+func (report LimitsReport) Exceeded() bool {
+	for _, v := range report.Issues {
+		if v.Exceeded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Computes what the map uses of each limit on a server with maxPlayers
+// slots and compares it with KnownEngineLimits. Only what the map itself
+// brings is counted: the files of resources shipped by the archive. Missing
+// files are left out, most are stock files the game precaches anyway.
+func AnalyzeLimits(b *bsp.BSP, resources MapResources, maxPlayers int) LimitsReport {
+	var models, sounds int
+	for _, v := range resources.Files {
+		switch strings.ToLower(path.Ext(v)) {
+		case ".mdl", ".spr":
+			models++
+		case ".wav":
+			sounds++
+		}
+	}
+
+	var (
+		// Every brush model is precached, the world being the first one.
+		used = map[LimitKind]int{
+			LimitModels:    max(len(b.Models), 1) + models,
+			LimitSounds:    sounds,
+			LimitEdicts:    len(b.Entities) + maxPlayers,
+			LimitEntData:   int(b.Lumps[bsp.LumpEntities].Length),
+			LimitClipNodes: len(b.ClipNodes),
+		}
+		ret LimitsReport
+	)
+	for _, kind := range limitKinds {
+		ret.Usage = append(ret.Usage, LimitUsage{Kind: kind, Used: used[kind]})
+
+		for _, engine := range KnownEngineLimits {
+			limit, ok := engine.Limits[kind]
+			if !ok || float64(used[kind]) < limitWarningRatio*float64(limit) {
+				continue
+			}
+
+			ret.Issues = append(ret.Issues, LimitIssue{
+				Kind:     kind,
+				Engine:   engine.Name,
+				Used:     used[kind],
+				Max:      limit,
+				Exceeded: used[kind] > limit,
+			})
+		}
+	}
+
+	return ret
+}
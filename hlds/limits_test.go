@@ -0,0 +1,79 @@
+package hlds_test
+
+import (
+	"fmt"
+	"hldsbot/bsp"
+	"hldsbot/hlds"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeLimits(t *testing.T) {
+	var (
+		entities  strings.Builder
+		resources = hlds.MapResources{Files: []string{"sprites/glow.spr", "custom.wad"}, Missing: []string{"models/tree.mdl"}}
+	)
+	entities.WriteString(`{"classname" "worldspawn" "wad" "custom.wad"}` + "\n")
+	entities.WriteString(`{"classname" "cycler_sprite" "model" "sprites/glow.spr"}` + "\n")
+	entities.WriteString(`{"classname" "env_model" "model" "models/tree.mdl"}` + "\n")
+	entities.WriteString(`{"classname" "env_model" "model" "models/player.mdl"}` + "\n")
+	for i := range 420 {
+		fmt.Fprintf(&entities, `{"classname" "ambient_generic" "message" "ambience/custom%d.wav"}`+"\n", i)
+		resources.Files = append(resources.Files, fmt.Sprintf("sound/ambience/custom%d.wav", i))
+	}
+
+	b, err := bsp.Parse(buildTestBSP(t, entities.String()))
+	require.NoError(t, err)
+
+	report := hlds.AnalyzeLimits(b, resources, 32)
+	require.Equal(t, []hlds.LimitUsage{
+		{Kind: hlds.LimitModels, Used: 2}, // missing files are not counted
+		{Kind: hlds.LimitSounds, Used: 420},
+		{Kind: hlds.LimitEdicts, Used: 424 + 32},
+		{Kind: hlds.LimitEntData, Used: entities.Len()},
+		{Kind: hlds.LimitClipNodes, Used: 0},
+	}, report.Usage)
+	require.Equal(t, []hlds.LimitIssue{
+		{Kind: hlds.LimitSounds, Engine: "GoldSrc", Used: 420, Max: 512},
+	}, report.Issues)
+	require.False(t, report.Exceeded())
+
+	for range 500 {
+		entities.WriteString(`{"classname" "info_target"}` + "\n")
+	}
+	b, err = bsp.Parse(buildTestBSP(t, entities.String()))
+	require.NoError(t, err)
+
+	report = hlds.AnalyzeLimits(b, resources, 32)
+	require.Contains(t, report.Issues, hlds.LimitIssue{
+		Kind: hlds.LimitEdicts, Engine: "GoldSrc defaults", Used: 924 + 32, Max: 900, Exceeded: true,
+	})
+	require.True(t, report.Exceeded())
+}
+
+func TestExtractLimits(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/foo.bsp": buildTestBSP(t, `{"classname" "worldspawn"}
+{"classname" "env_model" "model" "models/tree.mdl"}
+{"classname" "env_model" "model" "models/player.mdl"}
+`),
+		"models/tree.mdl": []byte("mdl"),
+	})
+
+	// Default embedded manifest, player.mdl is not counted whether it's
+	// listed or not.
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	_, err = ma.Extract(t.TempDir())
+	require.NoError(t, err)
+
+	report, ok := ma.Limits("foo")
+	require.True(t, ok)
+	require.Empty(t, report.Issues)
+	require.Equal(t, hlds.LimitUsage{Kind: hlds.LimitModels, Used: 2}, report.Usage[0], "world and the archive model")
+	require.Equal(t, hlds.LimitUsage{Kind: hlds.LimitEdicts, Used: 3 + hlds.ServerPlayerSlots}, report.Usage[2])
+}
@@ -3,20 +3,26 @@ package hlds
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
+	"hldsbot/bsp"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode/v2"
 	"github.com/rs/zerolog/log"
 )
 
 var MissingBSPErr = errors.New("no .bsp file in archive")
 var MultipleBSPErr = errors.New("multiple .bsp files found in archive")
+var UnknownMapErr = errors.New("map not found in archive")
 var InvalidPathErr = errors.New("archive contains paths with non-unicode characters")
 var UnknownArchiveErr = errors.New("archive is not in a format we can handle")
 
@@ -24,6 +30,24 @@ type MapArchive struct {
 	fs       fs.FS
 	fsCloser func() error
 	mapping  map[string]string // path in archive => path when extracting
+
+	maps     []string // names of all the BSPs found, sorted
+	selected []string // names of the BSPs to extract, all by default
+
+	resources    map[string]MapResources // by map name, set by Extract
+	engineLimits map[string]LimitsReport // by map name, set by Extract
+
+	collisions []PathCollision // set by newMapArchive
+	skipped    []string        // quoted names of entries we couldn't decode
+	report     *ExtractionReport
+
+	stock         *StockManifest
+	stockTextures *StockTextures
+	stockPolicy   StockPolicy
+	stockSkipped  []string // stock files not extracted, set by Extract
+
+	limits ArchiveLimits
+	size   int64 // of the archive file, to compute the compression ratio
 }
 
 type fsType int
@@ -33,6 +57,10 @@ const (
 	fsTypeZIP
 	fsType7z
 	fsTypeRAR
+	fsTypeTar
+	fsTypeTarGzip
+	fsTypeTarBzip2
+	fsTypeTarXZ
 )
 
 // Will return fsTypeInvalid with no error if the format is unknown.
@@ -43,26 +71,43 @@ func detectArchiveType(path string) (fsType, error) {
 	}
 	defer f.Close()
 
-	var buf = make([]byte, 7)
-	if _, err := f.Read(buf); err != nil {
+	// Large enough for the tar magic.
+	var buf = make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return fsTypeInvalid, fmt.Errorf("unable to read file header: %w", err)
 	}
+	buf = buf[:n]
+	if n < 8 {
+		return fsTypeInvalid, nil
+	}
 
 	switch {
 	case bytes.Equal(buf[:6], []byte{0x37, 0x7a, 0xbc, 0xaf, 0x27, 0x1c}):
 		return fsType7z, nil
 	case bytes.Equal(buf[:4], []byte{0x50, 0x4b, 0x03, 0x04}):
 		return fsTypeZIP, nil
-	case bytes.Equal(buf[:7], []byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x00}):
+	case bytes.Equal(buf[:7], []byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x00}), // RAR 1.5 to 4
+		bytes.Equal(buf[:8], []byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x01, 0x00}): // RAR 5
 		return fsTypeRAR, nil
+	case bytes.Equal(buf[:2], []byte{0x1f, 0x8b}):
+		return fsTypeTarGzip, nil
+	case bytes.Equal(buf[:3], []byte("BZh")):
+		return fsTypeTarBzip2, nil
+	case bytes.Equal(buf[:6], []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return fsTypeTarXZ, nil
+	case n >= 262 && bytes.Equal(buf[257:262], []byte("ustar")):
+		return fsTypeTar, nil
 	}
 
-	log.Debug().Hex("header", buf).Msg("unable to find correct file header")
+	log.Debug().Hex("header", buf[:8]).Msg("unable to find correct file header")
 
 	return fsTypeInvalid, nil
 }
 
-func archiveFSFactory(path string) (fs.FS, func() error, error) {
+// Compressed streams are assumed to be tar archives, limits and archiveSize
+// bound their decompression.
+func archiveFSFactory(path string, limits ArchiveLimits, archiveSize int64) (fs.FS, func() error, error) {
 	typ, err := detectArchiveType(path)
 	if err != nil {
 		return nil, nil, err
@@ -71,83 +116,462 @@ func archiveFSFactory(path string) (fs.FS, func() error, error) {
 	switch typ {
 	case fsTypeZIP:
 		zip, err := zip.OpenReader(path)
-		if err == nil {
-			return zip, zip.Close, nil
+		if err != nil {
+			return nil, nil, err
 		}
 
-		return nil, nil, err
+		index := newIndexFS()
+		for _, f := range zip.File {
+			index.add(f.Name, f.FileInfo(), f.Open)
+		}
+		return index, zip.Close, nil
 	case fsType7z:
 		szip, err := sevenzip.OpenReader(path)
-		if err == nil {
-			return szip, szip.Close, nil
+		if err != nil {
+			return nil, nil, err
 		}
-		return nil, nil, err
+
+		index := newIndexFS()
+		for _, f := range szip.File {
+			index.add(f.Name, f.FileInfo(), f.Open)
+		}
+		return index, szip.Close, nil
+	case fsTypeRAR:
+		files, err := rardecode.List(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		index := newIndexFS()
+		for _, f := range files {
+			index.add(f.Name, rarFileInfo{&f.FileHeader}, f.Open)
+		}
+		// Files are opened on demand, there's nothing to close.
+		return index, func() error { return nil }, nil
+	case fsTypeTar, fsTypeTarGzip, fsTypeTarBzip2, fsTypeTarXZ:
+		return openTarFS(path, typ, limits, archiveSize)
 	}
 
 	return nil, nil, UnknownArchiveErr
 }
 
-// Please don't upload zip bombs to TWHL.
+// Please don't upload zip bombs to TWHL, DefaultArchiveLimits apply.
 func ReadMapArchiveFromFile(path string) (*MapArchive, error) {
-	fs, closer, err := archiveFSFactory(path)
+	return ReadMapArchiveFromFileWithLimits(path, DefaultArchiveLimits)
+}
+
+// Limits are checked against the archive headers before returning, and
+// enforced again when extracting. A *LimitError is returned when they're
+// exceeded.
+// When the archive has no BSP but contains other archives, the first one with
+// a BSP is used instead. Only one level of nesting is supported.
+func ReadMapArchiveFromFileWithLimits(path string, limits ArchiveLimits) (*MapArchive, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat map archive: %w", err)
+	}
+
+	return readMapArchive(path, info.Size(), limits, true)
+}
+
+// size is the size of the archive that was uploaded, the compression ratio
+// of nested archives is computed against it.
+func readMapArchive(path string, size int64, limits ArchiveLimits, allowNested bool) (*MapArchive, error) {
+	fs, closer, err := archiveFSFactory(path, limits, size)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open map archive for reading: %w", err)
 	}
 
-	mapping, err := remapArchive(fs)
+	ma, err := newMapArchive(fs, size, limits)
+	if err != nil && allowNested && errors.Is(err, MissingBSPErr) {
+		nested, nestedErr := readNestedMapArchive(fs, size, limits)
+		if err := closer(); err != nil {
+			log.Error().Err(err).Msg("unable to close map archive")
+		}
+
+		if nestedErr != nil && !errors.Is(nestedErr, MissingBSPErr) {
+			return nil, nestedErr
+		}
+		if nestedErr != nil {
+			return nil, err
+		}
+		return nested, nil
+	}
+
+	if err != nil {
+		if err := closer(); err != nil {
+			log.Error().Err(err).Msg("unable to close map archive")
+		}
+		return nil, err
+	}
+	ma.fsCloser = closer
+	if index, ok := fs.(*indexFS); ok {
+		ma.skipped = index.skipped
+		for _, v := range index.skipped {
+			ma.report.set(v, OutcomeInvalidName, "", "")
+		}
+	}
+
+	return ma, nil
+}
+
+var nestedArchiveExts = []string{".7z", ".bz2", ".gz", ".rar", ".tar", ".tbz2", ".tgz", ".txz", ".xz", ".zip"}
+
+// Returns MissingBSPErr if no nested archive contains a BSP.
+func readNestedMapArchive(outer fs.FS, size int64, limits ArchiveLimits) (*MapArchive, error) {
+	var candidates []string
+	if err := fs.WalkDir(outer, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if !entry.IsDir() && !isPathGarbage(path) && slices.Contains(nestedArchiveExts, ext) {
+			candidates = append(candidates, path)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("unable to walk through archive: %w", err)
+	}
+	slices.Sort(candidates)
+
+	for _, name := range candidates {
+		log.Info().Str("archive", name).Msg("Looking for a BSP in nested archive.")
+
+		// Limits apply to the whole upload, anything else only rules out
+		// this candidate.
+		ma, err := readNestedCandidate(outer, name, size, limits)
+		var errLimit *LimitError
+		if errors.As(err, &errLimit) {
+			return nil, err
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("archive", name).Msg("nested archive is not usable")
+			continue
+		}
+
+		return ma, nil
+	}
+
+	return nil, MissingBSPErr
+}
+
+func readNestedCandidate(outer fs.FS, name string, size int64, limits ArchiveLimits) (*MapArchive, error) {
+	src, err := outer.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open nested archive: %w", err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "hldsbot-nested-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file: %w", err)
+	}
+	defer tmp.Close()
+
+	remove := func() {
+		if err := os.Remove(tmp.Name()); err != nil {
+			log.Error().Err(err).Str("path", tmp.Name()).Msg("unable to remove nested archive")
+		}
+	}
+
+	if _, err := limits.copy(tmp, src, name, size, 0); err != nil {
+		remove()
+		return nil, fmt.Errorf("unable to extract nested archive: %w", err)
+	}
+
+	ma, err := readMapArchive(tmp.Name(), size, limits, false)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+	ma.report.NestedArchive = name
+	ma.report.warn("map found in nested archive '%s', other files of the upload were ignored", name)
+
+	// Some readers open the file on demand, remove it only once done.
+	closer := ma.fsCloser
+	ma.fsCloser = func() error {
+		defer remove()
+		return closer()
+	}
+
+	return ma, nil
+}
+
+func newMapArchive(archive fs.FS, size int64, limits ArchiveLimits) (*MapArchive, error) {
+	report := newExtractionReport()
+	mapping, err := remapArchive(archive, limits, report)
 	if err != nil {
 		return nil, fmt.Errorf("unable to remap archive paths: %w", err)
 	}
 
-	mapping, err = sanitizeMapping(mapping)
+	mapping, err = sanitizeMapping(mapping, report)
 	if err != nil {
 		return nil, fmt.Errorf("mapping sanitizing failed: %w", err)
 	}
+
+	mapping, collisions := foldMapping(mapping)
+	if len(collisions) > 0 {
+		log.Warn().Interface("collisions", collisions).Msg("Archive contains paths differing only by case.")
+	}
+	for _, v := range collisions {
+		report.set(v.Dropped, OutcomeCaseCollision, "", v.Kept)
+	}
+	for src, dst := range mapping {
+		report.set(src, OutcomeKept, dst, "")
+	}
 	log.Debug().Interface("mapping", mapping).Msg("")
 
+	var srcNames = make([]string, 0, len(mapping))
+	for k := range mapping {
+		srcNames = append(srcNames, k)
+	}
+	if err := limits.checkSizes(archive, size, srcNames); err != nil {
+		return nil, err
+	}
+
+	var maps []string
+	for _, v := range mapping {
+		if name, ok := mapNameFromDest(v); ok {
+			maps = append(maps, name)
+		}
+	}
+	slices.Sort(maps)
+
 	return &MapArchive{
-		fs:       fs,
-		fsCloser: closer,
+		fs:       archive,
 		mapping:  mapping,
+		maps:     maps,
+		selected: maps,
+
+		collisions: collisions,
+		report:     report,
+
+		stock:         DefaultStockManifest,
+		stockTextures: DefaultStockTextures,
+		stockPolicy:   DefaultStockPolicy,
+
+		limits: limits,
+		size:   size,
 	}, nil
 }
 
-func (ma MapArchive) MapName() string {
-	for _, v := range ma.mapping {
-		if filepath.Dir(v) == "maps" && filepath.Ext(v) == ".bsp" {
-			return strings.TrimSuffix(filepath.Base(v), ".bsp")
+// Returns the name of the map if dst is the extraction path of a BSP.
+func mapNameFromDest(dst string) (string, bool) {
+	if filepath.Dir(dst) != "maps" || filepath.Ext(dst) != ".bsp" {
+		return "", false
+	}
+
+	return strings.TrimSuffix(filepath.Base(dst), ".bsp"), true
+}
+
+// Names of every map found in the archive, sorted.
+func (ma MapArchive) Maps() []string {
+	return slices.Clone(ma.maps)
+}
+
+// Restricts extraction to the given maps, in the given order. Resources are
+// shared by all maps and are always extracted.
+func (ma *MapArchive) Select(names ...string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("%w: no map selected", UnknownMapErr)
+	}
+
+	var selected = make([]string, 0, len(names))
+	for _, v := range names {
+		if !slices.Contains(ma.maps, v) {
+			return fmt.Errorf("%w: '%s'", UnknownMapErr, v)
+		}
+
+		if !slices.Contains(selected, v) {
+			selected = append(selected, v)
 		}
 	}
+	ma.selected = selected
+
+	return nil
+}
+
+// Names of the maps that will be extracted, the first one is meant to be
+// the startup map.
+func (ma MapArchive) SelectedMaps() []string {
+	return slices.Clone(ma.selected)
+}
+
+// Name of the first selected map.
+func (ma MapArchive) MapName() string {
+	if len(ma.selected) == 0 {
+		panic("unreachable, there should definitely be a map available at this point")
+	}
+
+	return ma.selected[0]
+}
+
+// Replaces DefaultStockTextures, must be called before Extract.
+func (ma *MapArchive) SetStockTextures(textures *StockTextures) {
+	ma.stockTextures = textures
+}
+
+// Archive files that were not extracted because another file had the same
+// path once case is ignored.
+func (ma MapArchive) Collisions() []PathCollision {
+	return slices.Clone(ma.collisions)
+}
+
+// Names of the archive entries that were ignored because they could not be
+// decoded or escape the archive, quoted as they're not always valid UTF-8.
+func (ma MapArchive) SkippedNames() []string {
+	return slices.Clone(ma.skipped)
+}
+
+// Outcome of every archive entry, complete after Extract.
+func (ma MapArchive) Report() ExtractionReport {
+	return ma.report.clone()
+}
+
+// Replaces DefaultStockManifest and DefaultStockPolicy, must be called
+// before Extract.
+func (ma *MapArchive) SetStockPolicy(manifest *StockManifest, policy StockPolicy) {
+	ma.stock = manifest
+	ma.stockPolicy = policy
+}
+
+// Archive files that were not extracted because they were copies or
+// overrides of stock files, only available after Extract.
+func (ma MapArchive) StockSkipped() []string {
+	return slices.Clone(ma.stockSkipped)
+}
+
+// Files referenced by a selected map, only available after Extract.
+func (ma MapArchive) Resources(mapName string) (MapResources, bool) {
+	resources, ok := ma.resources[mapName]
+	return resources, ok
+}
+
+// Engine limits usage of a selected map, only available after Extract.
+func (ma MapArchive) Limits(mapName string) (LimitsReport, bool) {
+	limits, ok := ma.engineLimits[mapName]
+	return limits, ok
+}
+
+// Returns false for the BSP and per-map files of maps that are not selected.
+func (ma MapArchive) isSelected(dst string) bool {
+	if filepath.Dir(dst) != "maps" {
+		return true
+	}
 
-	panic("unreachable, there should definitely be a map available at this point")
+	name := strings.TrimSuffix(filepath.Base(dst), filepath.Ext(dst))
+	return !slices.Contains(ma.maps, name) || slices.Contains(ma.selected, name)
 }
 
-func (ma MapArchive) Extract(dstBaseDir string) (int64, error) {
+// The .res file of each selected map is generated from the resources its
+// entities reference, see Resources. Spectator overviews are generated for
+// maps that don't ship one.
+// Files that are copies of stock files are skipped so they're not served,
+// files overriding stock files are handled according to the stock policy,
+// see SetStockPolicy.
+func (ma *MapArchive) Extract(dstBaseDir string) (int64, error) {
 	log.Info().Str("dst", dstBaseDir).Msg("Extracting archive to disk.")
 
 	var (
 		total          int64
 		extractedNames = make([]string, 0, len(ma.mapping))
-		mapName        string
+		overrides      []string
 	)
 
+	ma.stockSkipped = nil
 	for srcName, dstName := range ma.mapping {
-		written, err := ma.extractFile(srcName, filepath.Join(dstBaseDir, dstName))
+		if !ma.isSelected(dstName) {
+			ma.report.set(srcName, OutcomeNotSelected, "", "")
+			continue
+		}
+
+		var (
+			dstPath = filepath.Join(dstBaseDir, dstName)
+			hasher  hash.Hash
+		)
+		if ma.stock.Has(dstName) {
+			hasher = sha256.New()
+		}
+
+		written, err := ma.extractFile(srcName, dstPath, total, hasher)
 		total += written
 
-		extractedNames = append(extractedNames, dstName)
-		if filepath.Ext(dstName) == ".bsp" {
-			mapName = strings.TrimSuffix(dstName, ".bsp")
+		if err == nil && hasher != nil {
+			if ma.stock.Matches(dstName, hasher.Sum(nil)) {
+				ma.report.set(srcName, OutcomeStockCopy, "", dstName)
+			} else {
+				overrides = append(overrides, dstName)
+				ma.report.set(srcName, OutcomeStockOverride, "", dstName)
+			}
+			ma.stockSkipped = append(ma.stockSkipped, dstName)
+			if err := os.Remove(dstPath); err != nil {
+				return total, fmt.Errorf("unable to remove stock file '%s': %w", dstName, err)
+			}
+			continue
 		}
 
+		extractedNames = append(extractedNames, dstName)
+
 		if err != nil {
 			return total, fmt.Errorf("unable to extract from source '%s' to destination '%s': %w", srcName, dstName, err)
 		}
 	}
 
-	resPath := filepath.Join(dstBaseDir, mapName+".res")
-	if err := writeRESFile(resPath, extractedNames); err != nil {
-		log.Error().Err(err).Str("path", resPath).Msg("unable to write RES file")
+	slices.Sort(ma.stockSkipped)
+	if len(overrides) > 0 {
+		slices.Sort(overrides)
+		if ma.stockPolicy == StockPolicyReject {
+			return total, &StockOverrideError{Paths: overrides}
+		}
+		log.Warn().Strs("paths", overrides).Msg("Dropped archive files overriding stock files.")
+	}
+
+	slices.Sort(extractedNames)
+	ma.resources = make(map[string]MapResources, len(ma.selected))
+	ma.engineLimits = make(map[string]LimitsReport, len(ma.selected))
+	caseFixed := make(map[string]bool) // renamed for a previous map
+	for _, mapName := range ma.selected {
+		var (
+			bspPath   = filepath.Join(dstBaseDir, "maps", mapName+".bsp")
+			resources MapResources
+			renames   map[string]string
+		)
+		b, err := bsp.Open(bspPath)
+		if err != nil {
+			// Don't prevent playing maps we can't read, send everything.
+			log.Warn().Err(err).Str("bsp", bspPath).Msg("unable to resolve map resources")
+			for _, v := range extractedNames {
+				if filepath.Ext(v) != ".bsp" {
+					resources.Files = append(resources.Files, v)
+				}
+			}
+		} else {
+			resources, renames = readMapResources(dstBaseDir, b, extractedNames, ma.stock, ma.stockTextures)
+			if len(resources.Missing) > 0 {
+				log.Info().Str("bsp", bspPath).Strs("missing", resources.Missing).Msg("Map references files absent from the archive.")
+			}
+			if len(resources.MissingTextures) > 0 {
+				log.Info().Str("bsp", bspPath).Strs("textures", resources.MissingTextures).Msg("Map uses textures absent from the archive.")
+			}
+			if len(resources.UnknownStockWADs) > 0 {
+				log.Warn().Str("bsp", bspPath).Strs("wads", resources.UnknownStockWADs).Msg("unable to verify textures, stock WADs are unknown")
+				ma.report.warn("textures of '%s' were not verified, the textures of %s are unknown", mapName, strings.Join(resources.UnknownStockWADs, ", "))
+			}
+		}
+		ma.fixCase(dstBaseDir, resources.Files, renames, extractedNames, caseFixed)
+
+		if b != nil {
+			resources.Files = append(resources.Files, writeOverview(dstBaseDir, mapName, b, extractedNames)...)
+			ma.engineLimits[mapName] = AnalyzeLimits(b, resources, ServerPlayerSlots)
+		}
+		ma.resources[mapName] = resources
+
+		resPath := filepath.Join(dstBaseDir, "maps", mapName+".res")
+		if err := writeRESFile(resPath, resources.Files); err != nil {
+			log.Error().Err(err).Str("path", resPath).Msg("unable to write RES file")
+		}
 	}
 
 	log.Info().Str("dst", dstBaseDir).Int64("uncompressed", total).Msg("Archive extracted.")
@@ -155,10 +579,34 @@ func (ma MapArchive) Extract(dstBaseDir string) (int64, error) {
 	return total, nil
 }
 
+// Renames extracted files to the case the map uses, files and extracted are
+// updated in place. A file is only renamed for the first map referencing it.
+func (ma MapArchive) fixCase(baseDir string, files []string, renames map[string]string, extracted []string, fixed map[string]bool) {
+	for i, from := range files {
+		to, ok := renames[from]
+		if !ok || fixed[from] {
+			continue
+		}
+
+		if err := renameExtracted(baseDir, from, to); err != nil {
+			log.Warn().Err(err).Msg("unable to fix file case")
+			continue
+		}
+		log.Debug().Str("from", from).Str("to", to).Msg("renamed file to the case used by the map")
+		ma.report.renameDest(from, to)
+
+		files[i] = to
+		fixed[to] = true
+		if j := slices.Index(extracted, from); j >= 0 {
+			extracted[j] = to
+		}
+	}
+}
+
 func writeRESFile(path string, names []string) error {
 	log.Debug().Str("path", path).Strs("names", names).Msg("writing RES file")
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
 		return fmt.Errorf("unable to open res file for writing: %w", err)
 	}
@@ -178,7 +626,9 @@ func writeRESFile(path string, names []string) error {
 	return nil
 }
 
-func (ma MapArchive) extractFile(srcName, dstPath string) (int64, error) {
+// extracted is the number of bytes already extracted from the archive.
+// The written content is also fed to hash if not nil.
+func (ma MapArchive) extractFile(srcName, dstPath string, extracted int64, hash io.Writer) (int64, error) {
 	srcFile, err := ma.fs.Open(srcName)
 	if err != nil {
 		return 0, fmt.Errorf("unable to open source file '%s' in archive: %w", srcFile, err)
@@ -197,8 +647,14 @@ func (ma MapArchive) extractFile(srcName, dstPath string) (int64, error) {
 		return 0, fmt.Errorf("unable to create file: %w", err)
 	}
 
-	written, err := io.Copy(dstFile, srcFile)
+	var dst io.Writer = dstFile
+	if hash != nil {
+		dst = io.MultiWriter(dstFile, hash)
+	}
+
+	written, err := ma.limits.copy(dst, srcFile, srcName, ma.size, extracted)
 	if err != nil {
+		dstFile.Close()
 		return written, fmt.Errorf("unable to write to file: %w", err)
 	}
 
@@ -215,22 +671,42 @@ func (ma *MapArchive) Close() error {
 
 // Get a usable tree out of random archives. ie. put bsp in maps/ even if they're
 // at the root of the archive.
-func remapArchive(archive fs.FS) (map[string]string, error) {
-	var files []string
+func remapArchive(archive fs.FS, limits ArchiveLimits, report *ExtractionReport) (map[string]string, error) {
+	var (
+		files []string
+		count int
+	)
 
 	if err := fs.WalkDir(archive, ".", func(path string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("error sent to WalkDir callback: %w", err)
 		}
 
+		if !entry.IsDir() {
+			count++
+		}
+		if err := limits.checkEntry(path, count); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
 		if isPathGarbage(path) {
 			log.Debug().Str("path", path).Msg("skipping garbage")
+			report.set(path, OutcomeGarbage, "", "")
 			return nil
 		}
 
 		files = append(files, filepath.Clean(path))
 		return nil
 	}); err != nil {
+		var errLimit *LimitError
+		if errors.As(err, &errLimit) {
+			return nil, err
+		}
+
 		if errors.Is(err, fs.ErrInvalid) {
 			return nil, InvalidPathErr
 		}
@@ -240,7 +716,7 @@ func remapArchive(archive fs.FS) (map[string]string, error) {
 
 	log.Debug().Strs("files", files).Msg("")
 
-	return generateMapping(files)
+	return generateMapping(files, report)
 }
 
 func isPathGarbage(path string) bool {
@@ -253,39 +729,112 @@ func isPathGarbage(path string) bool {
 	return false
 }
 
-func generateMapping(files []string) (map[string]string, error) {
-	bspSrcPath, err := findBSPPath(files)
+func generateMapping(files []string, report *ExtractionReport) (map[string]string, error) {
+	bspSrcPaths, err := findBSPPaths(files)
 	if err != nil {
 		return nil, fmt.Errorf("unable to find BSP: %w", err)
 	}
 
 	var (
-		mapping = make(map[string]string, 1)
-		// Consider the dir where we found the BSP to be the maps dir and build
-		// the hierarchy from there (or rather: from its parent).
-		mapsDir = filepath.Dir(bspSrcPath)
-		baseDir = filepath.Dir(mapsDir)
+		mapping  = make(map[string]string, len(files))
+		srcBases = make(map[string]string, len(files))
+		baseDirs []string
 	)
 
-	// Lone BSP at the root of the archive, no other file is expected to be
-	// usable or in the right path in this archive. Bail.
-	if !strings.ContainsRune(bspSrcPath, '/') {
-		log.Info().Str("bsp", bspSrcPath).Msg("Found BSP at the archive's root.")
-		mapping[bspSrcPath] = filepath.Join("maps", bspSrcPath)
-		return mapping, nil
+	for _, bspSrcPath := range bspSrcPaths {
+		var mapsDir = filepath.Dir(bspSrcPath)
+
+		// Lone BSP at the root of the archive, or someone caring put a lone
+		// BSP and maybe a readme in a subdirectory to avoid zip bombing your
+		// cwd. No other file is expected to be usable or in the right path
+		// next to it.
+		if !strings.ContainsRune(bspSrcPath, '/') || !strings.EqualFold(filepath.Base(mapsDir), "maps") {
+			log.Info().Str("bsp", bspSrcPath).Msg("Found BSP outside of a maps dir.")
+			report.warn("BSP '%s' is not in a maps directory, files next to it are ignored", bspSrcPath)
+			mapping[bspSrcPath] = normalizeDest(filepath.Join("maps", filepath.Base(bspSrcPath)))
+			continue
+		}
+
+		// Consider the dir where we found the BSP to be the maps dir and
+		// build the hierarchy from there (or rather: from its parent).
+		baseDir := filepath.Dir(mapsDir)
+		log.Debug().Str("bsp", bspSrcPath).Str("base", baseDir).Msg("Found a proper hierarchy.")
+		if !slices.Contains(baseDirs, baseDir) {
+			baseDirs = append(baseDirs, baseDir)
+		}
 	}
 
-	// Someone caring put a lone BSP and maybe a readme in a subdirectory to
-	// avoid zip bombing your cwd. Assume a lone BSP and bail.
-	if filepath.Base(mapsDir) != "maps" {
-		mapping[bspSrcPath] = filepath.Join("maps", filepath.Base(bspSrcPath))
-		log.Warn().Str("bsp", bspSrcPath).Msg("Found BSP in a weird path.")
-		return mapping, nil
+	// Deepest hierarchy first, files are mapped relative to the closest one.
+	slices.SortFunc(baseDirs, func(a, b string) int {
+		return strings.Count(b, "/") - strings.Count(a, "/")
+	})
+	for _, baseDir := range baseDirs {
+		hierarchy, err := remapArchiveFromBaseDir(files, baseDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for src, dst := range hierarchy {
+			if _, ok := mapping[src]; !ok {
+				mapping[src] = normalizeDest(dst)
+				srcBases[src] = baseDir
+			}
+		}
 	}
 
-	log.Debug().Str("bsp", bspSrcPath).Str("base", baseDir).Msg("Found a proper hierarchy.")
-	mapping[bspSrcPath] = filepath.Join("maps", bspSrcPath)
-	return remapArchiveFromBaseDir(files, baseDir)
+	// Resources are what tells the real mod hierarchy apart from a stray
+	// copy of the BSP, BSPs themselves don't count.
+	var resources = make(map[string]int, len(baseDirs))
+	for src, baseDir := range srcBases {
+		if !strings.EqualFold(filepath.Ext(src), ".bsp") {
+			resources[baseDir]++
+		}
+	}
+	weights := make(map[string]int, len(srcBases))
+	for src, baseDir := range srcBases {
+		weights[src] = resources[baseDir]
+	}
+
+	for _, v := range files {
+		if _, ok := mapping[v]; !ok {
+			report.set(v, OutcomeOutsidePrefix, "", "")
+		}
+	}
+
+	return dedupeMapping(mapping, weights, report), nil
+}
+
+// Multiple hierarchies can contain the same files, for each destination keep
+// the source with the highest weight, then the first in lexical order.
+func dedupeMapping(mapping map[string]string, weights map[string]int, report *ExtractionReport) map[string]string {
+	var srcs = make([]string, 0, len(mapping))
+	for k := range mapping {
+		srcs = append(srcs, k)
+	}
+	slices.SortFunc(srcs, func(a, b string) int {
+		if weights[a] != weights[b] {
+			return weights[b] - weights[a]
+		}
+		return strings.Compare(a, b)
+	})
+
+	var (
+		ret  = make(map[string]string, len(mapping))
+		dsts = make(map[string]string, len(mapping))
+	)
+	for _, src := range srcs {
+		dst := mapping[src]
+		if other, ok := dsts[dst]; ok {
+			log.Debug().Str("src", src).Str("kept", other).Str("dst", dst).Msg("skipping duplicate destination")
+			report.set(src, OutcomeDuplicate, "", other)
+			continue
+		}
+
+		dsts[dst] = src
+		ret[src] = dst
+	}
+
+	return ret
 }
 
 func remapArchiveFromBaseDir(files []string, baseDir string) (map[string]string, error) {
@@ -338,7 +887,7 @@ func archivePathHasPrefix(path, prefix string) bool {
 	return strings.HasPrefix(path, prefix)
 }
 
-func sanitizeMapping(mapping map[string]string) (map[string]string, error) {
+func sanitizeMapping(mapping map[string]string, report *ExtractionReport) (map[string]string, error) {
 	var (
 		ret      = make(map[string]string, len(mapping))
 		foundBSP bool
@@ -347,6 +896,7 @@ func sanitizeMapping(mapping map[string]string) (map[string]string, error) {
 	for src, dst := range mapping {
 		if !isMappingDestValid(dst) {
 			log.Debug().Str("src", src).Str("dst", dst).Msg("discarding invalid path")
+			report.set(src, OutcomeDisallowedType, "", "")
 			continue
 		}
 
@@ -364,11 +914,69 @@ func sanitizeMapping(mapping map[string]string) (map[string]string, error) {
 	return ret, nil
 }
 
+// Two archive files ending up at the same path once case is ignored, only
+// Kept is extracted.
+type PathCollision struct {
+	Dest    string `json:"dest"`
+	Kept    string `json:"kept"`
+	Dropped string `json:"dropped"`
+}
+
+// The server runs on a case-sensitive filesystem, archives made on Windows
+// can contain the same path with different cases. Keep the first source in
+// lexical order for each destination once case is folded.
+func foldMapping(mapping map[string]string) (map[string]string, []PathCollision) {
+	var srcs = make([]string, 0, len(mapping))
+	for k := range mapping {
+		srcs = append(srcs, k)
+	}
+	slices.Sort(srcs)
+
+	var (
+		ret        = make(map[string]string, len(mapping))
+		kept       = make(map[string]string, len(mapping)) // folded dst => src
+		collisions []PathCollision
+	)
+	for _, src := range srcs {
+		dst := mapping[src]
+		folded := strings.ToLower(dst)
+		if other, ok := kept[folded]; ok {
+			collisions = append(collisions, PathCollision{Dest: dst, Kept: other, Dropped: src})
+			continue
+		}
+
+		kept[folded] = src
+		ret[src] = dst
+	}
+
+	return ret, collisions
+}
+
+// Directories the engine looks into, always lowercase.
+var engineDirs = []string{"gfx/env", "maps", "models", "overviews", "sound", "sprites"}
+
+// Puts the engine directories and the extension of dst in lowercase, eg.
+// MAPS/DM_Foo.BSP becomes maps/DM_Foo.bsp. The rest is left as is, the case
+// of referenced files is fixed when extracting, see MapResources.
+func normalizeDest(dst string) string {
+	ext := filepath.Ext(dst)
+	dst = strings.TrimSuffix(dst, ext) + strings.ToLower(ext)
+
+	for _, dir := range engineDirs {
+		if len(dst) > len(dir) && dst[len(dir)] == '/' && strings.EqualFold(dst[:len(dir)], dir) {
+			return dir + dst[len(dir):]
+		}
+	}
+
+	return dst
+}
+
 func isMappingDestValid(dst string) bool {
 	var (
-		dir = filepath.Dir(dst)
-		ext = filepath.Ext(dst)
+		dir = strings.ToLower(filepath.Dir(dst))
+		ext = strings.ToLower(filepath.Ext(dst))
 	)
+	dst = strings.ToLower(dst)
 
 	switch {
 	case dir == "." && ext == ".wad",
@@ -384,24 +992,21 @@ func isMappingDestValid(dst string) bool {
 	return false
 }
 
-func findBSPPath(paths []string) (string, error) {
-	var ret string
+func findBSPPaths(paths []string) ([]string, error) {
+	var ret []string
 
 	for _, path := range paths {
 		if strings.Contains(path, "..") {
-			return "", fmt.Errorf("archive contains invalid paths: %s", path)
+			return nil, fmt.Errorf("archive contains invalid paths: %s", path)
 		}
 
-		if strings.HasSuffix(path, ".bsp") {
-			if ret != "" {
-				return "", MultipleBSPErr
-			}
-			ret = path
+		if strings.EqualFold(filepath.Ext(path), ".bsp") {
+			ret = append(ret, path)
 		}
 	}
 
-	if ret == "" {
-		return "", MissingBSPErr
+	if len(ret) == 0 {
+		return nil, MissingBSPErr
 	}
 
 	return ret, nil
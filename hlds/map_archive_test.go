@@ -1,12 +1,23 @@
 package hlds_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"hldsbot/hlds"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
 )
 
 var testVaultExpectedFailures = map[string]error{
@@ -20,39 +31,11 @@ var testVaultExpectedFailures = map[string]error{
 	"vault_test/twhl-vault-5433.zip": hlds.MissingBSPErr,
 	"vault_test/twhl-vault-5514.zip": hlds.MissingBSPErr,
 	"vault_test/twhl-vault-5688.zip": hlds.MissingBSPErr,
-
-	"vault_test/twhl-vault-1467.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5149.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5292.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5484.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5607.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5612.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6141.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6336.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6521.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6619.zip": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6798.zip": hlds.MultipleBSPErr,
-
-	// This one is peculiar. Will open correctly if parsed manually using the
-	// zip package but not its fs.FS interface implementation.
-	// There's a "C2 B4" in it that's not a valid unicode char.
-	"vault_test/twhl-vault-6621.zip": hlds.InvalidPathErr,
 	// }}}
 
 	// {{{ 7z
 	"vault_test/twhl-vault-1485.7z": hlds.MissingBSPErr,
 	"vault_test/twhl-vault-2453.7z": hlds.MissingBSPErr,
-
-	"vault_test/twhl-vault-2665.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-3776.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5130.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5132.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5133.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5145.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5192.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5613.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-5655.7z": hlds.MultipleBSPErr,
-	"vault_test/twhl-vault-6733.7z": hlds.MultipleBSPErr,
 	// }}}
 }
 
@@ -87,3 +70,750 @@ func testVaultArchives(t *testing.T, ext string) {
 		require.NoError(t, ma.Close(), "can close %s without error %s", ext, path)
 	}
 }
+
+func TestRARArchives(t *testing.T) {
+	for _, path := range []string{"testdata/map.rar4.rar", "testdata/map.rar5.rar"} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			ma, err := hlds.ReadMapArchiveFromFile(path)
+			require.NoError(t, err)
+			defer ma.Close()
+
+			require.Equal(t, "rartest", ma.MapName())
+
+			dir := t.TempDir()
+			_, err = ma.Extract(dir)
+			require.NoError(t, err)
+
+			bsp, err := os.ReadFile(filepath.Join(dir, "maps/rartest.bsp"))
+			require.NoError(t, err)
+			require.Equal(t, "not really a BSP", string(bsp))
+			require.FileExists(t, filepath.Join(dir, "gfx/env/rartestbk.tga"))
+			require.FileExists(t, filepath.Join(dir, "sound/rartest.wav"), "backslashes are separators")
+			require.NoFileExists(t, filepath.Join(dir, "readme.txt"), "unknown files are not extracted")
+			require.Equal(t, []string{`"../rartest.txt"`}, ma.SkippedNames(), "entries escaping the archive are skipped")
+		})
+	}
+}
+
+func writeTestZIP(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = fw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	return path
+}
+
+func TestLegacyNames(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/caf\x82.bsp":             []byte("CP437"),
+		"sound/ambience/\xe9t\xe9.wav": []byte("CP1252"),
+		"sound/bad\x07.wav":            []byte("control character"),
+		`models\tree.mdl`:              []byte("backslashes"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	require.Equal(t, []string{"café"}, ma.Maps())
+	require.Equal(t, []string{`"sound/bad\a.wav"`}, ma.SkippedNames())
+
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+	for _, v := range []string{"maps/café.bsp", "sound/ambience/été.wav", "models/tree.mdl"} {
+		require.FileExists(t, filepath.Join(dir, v))
+	}
+}
+
+// Sorted for reproducible archives.
+func writeTestTar(t *testing.T, w io.Writer, files map[string][]byte) {
+	t.Helper()
+
+	var names = make([]string, 0, len(files))
+	for k := range files {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(files[name])),
+		}))
+		_, err := tw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func TestTarArchives(t *testing.T) {
+	var files = map[string][]byte{
+		"mymap/maps/tartest.bsp":        []byte("not really a BSP"),
+		"mymap/gfx/env/tartestbk.tga":   []byte("not really a TGA"),
+		"mymap/sound/ambience/wind.wav": bytes.Repeat([]byte("wind"), 1000),
+		"mymap/readme.txt":              []byte("hello"),
+	}
+
+	compressors := map[string]func(w io.Writer) (io.WriteCloser, error){
+		"tar": func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		"tar.gz": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		"tar.xz": func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+	}
+
+	for ext, compressor := range compressors {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "map."+ext)
+			f, err := os.Create(path)
+			require.NoError(t, err)
+			cw, err := compressor(f)
+			require.NoError(t, err)
+			writeTestTar(t, cw, files)
+			require.NoError(t, cw.Close())
+			require.NoError(t, f.Close())
+
+			ma, err := hlds.ReadMapArchiveFromFile(path)
+			require.NoError(t, err)
+			defer ma.Close()
+
+			require.Equal(t, "tartest", ma.MapName())
+
+			dir := t.TempDir()
+			_, err = ma.Extract(dir)
+			require.NoError(t, err)
+
+			wav, err := os.ReadFile(filepath.Join(dir, "sound/ambience/wind.wav"))
+			require.NoError(t, err)
+			require.Equal(t, files["mymap/sound/ambience/wind.wav"], wav)
+			require.FileExists(t, filepath.Join(dir, "gfx/env/tartestbk.tga"))
+			require.FileExists(t, filepath.Join(dir, "maps/tartest.bsp"))
+		})
+	}
+
+	// No bzip2 writer in the standard library, created using:
+	// tar --sort=name -cf - mymap | bzip2 -9 > map.tar.bz2
+	t.Run("tar.bz2", func(t *testing.T) {
+		ma, err := hlds.ReadMapArchiveFromFile("testdata/map.tar.bz2")
+		require.NoError(t, err)
+		defer ma.Close()
+
+		require.Equal(t, "bztest", ma.MapName())
+
+		dir := t.TempDir()
+		_, err = ma.Extract(dir)
+		require.NoError(t, err)
+
+		bsp, err := os.ReadFile(filepath.Join(dir, "maps/bztest.bsp"))
+		require.NoError(t, err)
+		require.Equal(t, "not really a BSP", string(bsp))
+	})
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestNestedArchives(t *testing.T) {
+	var inner bytes.Buffer
+	gw := gzip.NewWriter(&inner)
+	writeTestTar(t, gw, map[string][]byte{
+		"maps/nested.bsp": []byte("bsp"),
+		"nested.wad":      []byte("wad"),
+	})
+	require.NoError(t, gw.Close())
+
+	t.Run("one level", func(t *testing.T) {
+		path := writeTestZIP(t, map[string][]byte{
+			"readme.txt":      []byte("the map is in the tarball"),
+			"docs.zip":        []byte("not an archive"),
+			"upload/map.tgz":  inner.Bytes(),
+			"__MACOSX/x.tgz":  []byte("garbage"),
+			"sound/stray.wav": []byte("outer files are ignored"),
+		})
+
+		ma, err := hlds.ReadMapArchiveFromFile(path)
+		require.NoError(t, err)
+		require.Equal(t, []string{"nested"}, ma.Maps())
+
+		dir := t.TempDir()
+		_, err = ma.Extract(dir)
+		require.NoError(t, err)
+		require.FileExists(t, filepath.Join(dir, "maps/nested.bsp"))
+		require.FileExists(t, filepath.Join(dir, "nested.wad"))
+		require.NoFileExists(t, filepath.Join(dir, "sound/stray.wav"))
+		require.NoError(t, ma.Close())
+	})
+
+	t.Run("corrupt candidates", func(t *testing.T) {
+		corrupt := []byte("PK\x03\x04corrupt")
+
+		ma, err := hlds.ReadMapArchiveFromFile(writeTestZIP(t, map[string][]byte{
+			"a.zip":   corrupt,
+			"map.tgz": inner.Bytes(),
+		}))
+		require.NoError(t, err)
+		require.Equal(t, []string{"nested"}, ma.Maps())
+		require.NoError(t, ma.Close())
+
+		_, err = hlds.ReadMapArchiveFromFile(writeTestZIP(t, map[string][]byte{"a.zip": corrupt}))
+		require.ErrorIs(t, err, hlds.MissingBSPErr)
+	})
+
+	t.Run("two levels", func(t *testing.T) {
+		middle := writeTestZIP(t, map[string][]byte{"map.tgz": inner.Bytes()})
+		buf, err := os.ReadFile(middle)
+		require.NoError(t, err)
+
+		_, err = hlds.ReadMapArchiveFromFile(writeTestZIP(t, map[string][]byte{"middle.zip": buf}))
+		require.ErrorIs(t, err, hlds.MissingBSPErr)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		limits := hlds.DefaultArchiveLimits
+		limits.MaxFileSize = 16
+
+		_, err := hlds.ReadMapArchiveFromFileWithLimits(writeTestZIP(t, map[string][]byte{"map.tgz": inner.Bytes()}), limits)
+		var errLimit *hlds.LimitError
+		require.ErrorAs(t, err, &errLimit)
+		require.Equal(t, "MaxFileSize", errLimit.Limit)
+	})
+}
+
+func TestTarBomb(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bomb.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	gw := gzip.NewWriter(f)
+	writeTestTar(t, gw, map[string][]byte{
+		"maps/bomb.bsp": make([]byte, 8<<20),
+	})
+	require.NoError(t, gw.Close())
+	require.NoError(t, f.Close())
+
+	limits := hlds.DefaultArchiveLimits
+	limits.MaxTotalSize = 1 << 20
+
+	_, err = hlds.ReadMapArchiveFromFileWithLimits(path, limits)
+	var errLimit *hlds.LimitError
+	require.ErrorAs(t, err, &errLimit, "decompression is bounded")
+}
+
+func TestArchiveLimits(t *testing.T) {
+	cases := []struct {
+		name   string
+		files  map[string][]byte
+		limits hlds.ArchiveLimits
+		limit  string
+	}{
+		{
+			name:   "ratio",
+			files:  map[string][]byte{"maps/bomb.bsp": make([]byte, 10<<20)},
+			limits: hlds.DefaultArchiveLimits,
+			limit:  "MaxCompressionRatio",
+		},
+		{
+			name:   "file size",
+			files:  map[string][]byte{"maps/big.bsp": []byte("0123456789abcdef")},
+			limits: hlds.ArchiveLimits{MaxFileSize: 10},
+			limit:  "MaxFileSize",
+		},
+		{
+			name: "total size",
+			files: map[string][]byte{
+				"maps/a.bsp":  []byte("0123456789"),
+				"sound/a.wav": []byte("0123456789"),
+			},
+			limits: hlds.ArchiveLimits{MaxTotalSize: 15},
+			limit:  "MaxTotalSize",
+		},
+		{
+			name:   "depth",
+			files:  map[string][]byte{"a/b/c/maps/deep.bsp": []byte("bsp")},
+			limits: hlds.ArchiveLimits{MaxPathDepth: 3},
+			limit:  "MaxPathDepth",
+		},
+		{
+			name: "count",
+			files: map[string][]byte{
+				"maps/a.bsp":  []byte("bsp"),
+				"sound/a.wav": []byte("wav"),
+				"sound/b.wav": []byte("wav"),
+			},
+			limits: hlds.ArchiveLimits{MaxFiles: 2},
+			limit:  "MaxFiles",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := hlds.ReadMapArchiveFromFileWithLimits(writeTestZIP(t, c.files), c.limits)
+
+			var errLimit *hlds.LimitError
+			require.ErrorAs(t, err, &errLimit)
+			require.Equal(t, c.limit, errLimit.Limit)
+		})
+	}
+
+	ma, err := hlds.ReadMapArchiveFromFile(writeTestZIP(t, map[string][]byte{"maps/ok.bsp": []byte("bsp")}))
+	require.NoError(t, err)
+	require.NoError(t, ma.Close())
+}
+
+func TestMultipleMaps(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"pack/maps/b.bsp":  []byte("b"),
+		"pack/maps/a.bsp":  []byte("a"),
+		"pack/maps/b.cfg":  []byte("mp_fraglimit 10"),
+		"pack/sound/x.wav": []byte("wav"),
+		"extra/c.bsp":      []byte("c"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	require.Equal(t, []string{"a", "b", "c"}, ma.Maps())
+	require.Equal(t, "a", ma.MapName())
+	require.ErrorIs(t, ma.Select("nope"), hlds.UnknownMapErr)
+
+	require.NoError(t, ma.Select("b", "c"))
+	require.Equal(t, "b", ma.MapName())
+
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+
+	for _, v := range []string{"maps/b.bsp", "maps/b.cfg", "maps/b.res", "maps/c.bsp", "maps/c.res", "sound/x.wav"} {
+		require.FileExists(t, filepath.Join(dir, v))
+	}
+	require.NoFileExists(t, filepath.Join(dir, "maps/a.bsp"), "unselected maps are not extracted")
+}
+
+// Minimal v30 BSP with only an entity lump and a texture lump referencing
+// the given WAD textures.
+func buildTestBSP(t *testing.T, entities string, textures ...string) []byte {
+	t.Helper()
+
+	var lump bytes.Buffer
+	require.NoError(t, binary.Write(&lump, binary.LittleEndian, uint32(len(textures))))
+	for i := range textures {
+		require.NoError(t, binary.Write(&lump, binary.LittleEndian, int32(4+4*len(textures)+40*i)))
+	}
+	for _, v := range textures {
+		lump.Write(testMipTexHeader(t, v))
+	}
+	if len(textures) == 0 {
+		lump.Reset()
+	}
+
+	const headerSize = 4 + 15*8
+	var (
+		buf        bytes.Buffer
+		texturePos = headerSize + int32(len(entities))
+		end        = texturePos + int32(lump.Len())
+	)
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(30)))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [2]int32{headerSize, int32(len(entities))}))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [2]int32{end, 0}))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [2]int32{texturePos, int32(lump.Len())}))
+	for i := 3; i < 15; i++ {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, [2]int32{end, 0}))
+	}
+	buf.WriteString(entities)
+	buf.Write(lump.Bytes())
+
+	return buf.Bytes()
+}
+
+// 16×16 miptex header without mip levels.
+func testMipTexHeader(t *testing.T, name string) []byte {
+	t.Helper()
+
+	var (
+		buf     bytes.Buffer
+		rawName [16]byte
+	)
+	copy(rawName[:], name)
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, rawName))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [6]uint32{16, 16}))
+
+	return buf.Bytes()
+}
+
+// WAD3 holding miptex headers for the given names.
+func buildTestWAD(t *testing.T, textures ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [4]byte{'W', 'A', 'D', '3'}))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, [2]int32{int32(len(textures)), int32(12 + 40*len(textures))}))
+	for _, v := range textures {
+		buf.Write(testMipTexHeader(t, v))
+	}
+	for i, v := range textures {
+		var name [16]byte
+		copy(name[:], v)
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, [3]int32{int32(12 + 40*i), 40, 40}))
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, [4]uint8{0x43}))
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, name))
+	}
+
+	return buf.Bytes()
+}
+
+func TestMapResources(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/res.bsp": buildTestBSP(t, `{
+"classname" "worldspawn"
+"wad" "C:\sierra\half-life\valve\halflife.wad;C:\mapping\Custom.wad"
+"skyname" "mysky"
+}
+{
+"classname" "ambient_generic"
+"message" "ambience/custom.wav"
+}
+{
+"classname" "func_door"
+"model" "*1"
+"noise1" "doors/missing.wav"
+"message" "Not a file."
+}
+{
+"classname" "env_sprite"
+"model" "sprites/glow.spr"
+}
+{
+"classname" "scripted_sentence"
+"sentence" "!HG_ALERT1"
+}
+`),
+		"maps/res.res":              []byte("unrelated.wad\n"),
+		"custom.wad":                []byte("wad"),
+		"unused.wad":                []byte("wad"),
+		"gfx/env/myskyup.tga":       []byte("tga"),
+		"sound/ambience/custom.wav": []byte("wav"),
+		"sound/unused.wav":          []byte("wav"),
+		"sprites/glow.spr":          []byte("spr"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+
+	res, err := os.ReadFile(filepath.Join(dir, "maps/res.res"))
+	require.NoError(t, err)
+	require.Equal(t, "Custom.wad\ngfx/env/myskyup.tga\nsound/ambience/custom.wav\nsprites/glow.spr\n", string(res))
+	require.FileExists(t, filepath.Join(dir, "Custom.wad"), "renamed to the case used by the map")
+
+	resources, ok := ma.Resources("res")
+	require.True(t, ok)
+	require.Equal(t, []string{
+		"gfx/env/myskybk.tga",
+		"gfx/env/myskydn.tga",
+		"gfx/env/myskyft.tga",
+		"gfx/env/myskylf.tga",
+		"gfx/env/myskyrt.tga",
+		"sound/doors/missing.wav",
+	}, resources.Missing, "stock WADs are not missing")
+}
+
+func TestWindowsArchive(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"DM_Foo/MAPS/DM_Foo.BSP": buildTestBSP(t, `{
+"classname" "worldspawn"
+"wad" "C:\mapping\foo.wad"
+}
+{
+"classname" "ambient_generic"
+"message" "ambience/Wind.wav"
+}
+{
+"classname" "env_model"
+"model" "models/tree.mdl"
+}
+`),
+		"DM_Foo/FOO.WAD":                  []byte("wad"),
+		"DM_Foo/Sound/Ambience/WIND.WAV":  []byte("wind"),
+		"DM_Foo/sound/ambience/wind.wav":  []byte("other wind"),
+		"DM_Foo/MODELS/Tree.MDL":          []byte("mdl"),
+		"DM_Foo/Overviews/DM_Foo.BMP":     []byte("bmp"),
+		"DM_Foo/GFX/ENV/DM_FooSkyUP.TGA":  []byte("tga"),
+		"DM_Foo/DLLS/hl.so":               []byte("nope"),
+		"DM_Foo/Addons/MetaMod/Stuff.mdl": []byte("nope"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	require.Equal(t, []string{"DM_Foo"}, ma.Maps())
+	require.Equal(t, []hlds.PathCollision{{
+		Dest:    "sound/ambience/wind.wav",
+		Kept:    "DM_Foo/Sound/Ambience/WIND.WAV",
+		Dropped: "DM_Foo/sound/ambience/wind.wav",
+	}}, ma.Collisions())
+
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+
+	for _, v := range []string{
+		"maps/DM_Foo.bsp",
+		"maps/DM_Foo.res",
+		"foo.wad",
+		"sound/ambience/Wind.wav",
+		"models/tree.mdl",
+		"overviews/DM_Foo.bmp",
+		"gfx/env/DM_FooSkyUP.tga",
+	} {
+		require.FileExists(t, filepath.Join(dir, v))
+	}
+	require.NoDirExists(t, filepath.Join(dir, "DLLS"))
+	require.NoDirExists(t, filepath.Join(dir, "Addons"))
+
+	wind, err := os.ReadFile(filepath.Join(dir, "sound/ambience/Wind.wav"))
+	require.NoError(t, err)
+	require.Equal(t, "wind", string(wind))
+
+	resources, ok := ma.Resources("DM_Foo")
+	require.True(t, ok)
+	require.Equal(t, []string{"foo.wad", "models/tree.mdl", "sound/ambience/Wind.wav"}, resources.Files)
+}
+
+func TestMissingTextures(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/tex.bsp": buildTestBSP(t, `{
+"classname" "worldspawn"
+"wad" "C:\mapping\custom.wad;C:\mapping\absent.wad"
+}
+`, "CRATE01", "{FENCE", "NOWHERE"),
+		"custom.wad": buildTestWAD(t, "crate01", "{fence"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	_, err = ma.Extract(t.TempDir())
+	require.NoError(t, err)
+
+	resources, ok := ma.Resources("tex")
+	require.True(t, ok)
+	require.Equal(t, []string{"absent.wad"}, resources.Missing)
+	require.Equal(t, []string{"nowhere"}, resources.MissingTextures)
+}
+
+func TestMissingTexturesStockWADs(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/tex.bsp": buildTestBSP(t, `{
+"classname" "worldspawn"
+"wad" "C:\sierra\half-life\valve\halflife.wad;C:\mapping\custom.wad"
+}
+`, "CRATE01", "{FENCE", "NOWHERE", "C1A0_LABW1"),
+		"custom.wad": buildTestWAD(t, "{fence"),
+	})
+
+	stockTextures, err := hlds.ParseStockTextures(strings.NewReader(
+		"# comment\nhalflife.wad crate01\nhalflife.wad c1a0_labw1\nliquids.wad nowhere\n",
+	))
+	require.NoError(t, err)
+	require.Equal(t, 3, stockTextures.Len())
+
+	extract := func(textures *hlds.StockTextures) (hlds.MapResources, hlds.ExtractionReport) {
+		ma, err := hlds.ReadMapArchiveFromFile(path)
+		require.NoError(t, err)
+		defer ma.Close()
+
+		ma.SetStockTextures(textures)
+		_, err = ma.Extract(t.TempDir())
+		require.NoError(t, err)
+
+		resources, ok := ma.Resources("tex")
+		require.True(t, ok)
+
+		return resources, ma.Report()
+	}
+
+	resources, report := extract(stockTextures)
+	require.Equal(t, []string{"nowhere"}, resources.MissingTextures, "only textures of referenced WADs are found")
+	require.Empty(t, resources.UnknownStockWADs)
+	require.Empty(t, report.Warnings)
+
+	unknown, err := hlds.ParseStockTextures(strings.NewReader(""))
+	require.NoError(t, err)
+	resources, report = extract(unknown)
+	require.Empty(t, resources.MissingTextures, "unknown stock WADs can't be verified")
+	require.Equal(t, []string{"halflife.wad"}, resources.UnknownStockWADs)
+	require.Equal(t, []string{"textures of 'tex' were not verified, the textures of halflife.wad are unknown"}, report.Warnings)
+
+	_, err = hlds.ParseStockTextures(strings.NewReader("halflife.wad\n"))
+	require.Error(t, err)
+}
+
+func TestStockFiles(t *testing.T) {
+	var manifest bytes.Buffer
+	for path, content := range map[string]string{
+		"models/player.mdl":          "stock player",
+		"sound/weapons/Glock1.wav":   "stock glock",
+		"sound/weapons/shotgun1.wav": "stock shotgun",
+	} {
+		fmt.Fprintf(&manifest, "%x  %s\n", sha256.Sum256([]byte(content)), path)
+	}
+	stock, err := hlds.ParseStockManifest(&manifest)
+	require.NoError(t, err)
+	require.Equal(t, 3, stock.Len())
+
+	path := writeTestZIP(t, map[string][]byte{
+		"maps/stock.bsp":            []byte("bsp"),
+		"models/player.mdl":         []byte("not the stock player"),
+		"models/custom.mdl":         []byte("custom"),
+		"sound/weapons/glock1.wav":  []byte("stock glock"),
+		"sound/ambience/custom.wav": []byte("custom"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	ma.SetStockPolicy(stock, hlds.StockPolicyDrop)
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"models/player.mdl", "sound/weapons/glock1.wav"}, ma.StockSkipped())
+	require.NoFileExists(t, filepath.Join(dir, "models/player.mdl"))
+	require.NoFileExists(t, filepath.Join(dir, "sound/weapons/glock1.wav"))
+	require.FileExists(t, filepath.Join(dir, "models/custom.mdl"))
+	require.FileExists(t, filepath.Join(dir, "sound/ambience/custom.wav"))
+
+	ma.SetStockPolicy(stock, hlds.StockPolicyReject)
+	_, err = ma.Extract(t.TempDir())
+	var errStock *hlds.StockOverrideError
+	require.ErrorAs(t, err, &errStock)
+	require.ErrorIs(t, err, hlds.StockOverrideErr)
+	require.Equal(t, []string{"models/player.mdl"}, errStock.Paths, "identical copies are not overrides")
+}
+
+func TestDefaultStockManifest(t *testing.T) {
+	// Generated from the game files, the bot refuses to start without it.
+	if hlds.DefaultStockManifest.Len() == 0 || hlds.DefaultStockTextures.Len() == 0 {
+		require.ErrorIs(t, hlds.CheckStockData(), hlds.MissingStockDataErr)
+		return
+	}
+
+	require.NoError(t, hlds.CheckStockData())
+	require.True(t, hlds.DefaultStockManifest.Has("models/player.mdl"))
+	require.True(t, hlds.DefaultStockManifest.Has("halflife.wad"))
+	require.True(t, hlds.DefaultStockTextures.Knows("halflife.wad"))
+}
+
+func TestParseStockManifestInvalid(t *testing.T) {
+	_, err := hlds.ParseStockManifest(strings.NewReader("# comment\nnothash  models/player.mdl\n"))
+	require.Error(t, err)
+
+	_, err = hlds.ParseStockManifest(strings.NewReader("models/player.mdl\n"))
+	require.Error(t, err)
+}
+
+func TestExtractionReport(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"pack/maps/a.bsp":        []byte("a"),
+		"pack/maps/b.bsp":        []byte("b"),
+		"pack/maps/a.res":        []byte("we write our own"),
+		"pack/sound/Wind.wav":    []byte("wind"),
+		"pack/sound/wind.wav":    []byte("other wind"),
+		"pack/readme.txt":        []byte("hello"),
+		"__MACOSX/pack/._a.bsp":  []byte("garbage"),
+		"stray.bsp":              []byte("outside maps/"),
+		"docs/screenshot.png":    []byte("png"),
+		"other/maps/a.bsp":       []byte("same map elsewhere"),
+		"other/sprites/glow.spr": []byte("spr"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	require.NoError(t, ma.Select("a"))
+	_, err = ma.Extract(t.TempDir())
+	require.NoError(t, err)
+
+	report := ma.Report()
+	outcomes := make(map[string]hlds.ReportEntry, len(report.Entries))
+	for _, v := range report.Entries {
+		outcomes[v.Path] = v
+	}
+
+	for path, expected := range map[string]hlds.EntryOutcome{
+		"__MACOSX/pack/._a.bsp":  hlds.OutcomeGarbage,
+		"docs/screenshot.png":    hlds.OutcomeOutsidePrefix,
+		"other/maps/a.bsp":       hlds.OutcomeDuplicate,
+		"other/sprites/glow.spr": hlds.OutcomeKept,
+		"pack/maps/a.bsp":        hlds.OutcomeKept,
+		"pack/maps/a.res":        hlds.OutcomeDisallowedType,
+		"pack/maps/b.bsp":        hlds.OutcomeNotSelected,
+		"pack/readme.txt":        hlds.OutcomeDisallowedType,
+		"pack/sound/Wind.wav":    hlds.OutcomeKept,
+		"pack/sound/wind.wav":    hlds.OutcomeCaseCollision,
+		"stray.bsp":              hlds.OutcomeNotSelected,
+	} {
+		require.Equal(t, expected, outcomes[path].Outcome, path)
+	}
+	require.Len(t, report.Entries, 11)
+	require.Equal(t, "maps/a.bsp", outcomes["pack/maps/a.bsp"].Dest)
+	require.Equal(t, "pack/maps/a.bsp", outcomes["other/maps/a.bsp"].Detail, "the hierarchy with the most resources wins")
+	require.Equal(t, 3, report.Counts()[hlds.OutcomeKept])
+	require.True(t, report.HasIssues())
+	require.Len(t, report.Warnings, 1, "stray.bsp is outside of a maps dir")
+}
+
+func TestDuplicateBSPPrefersResources(t *testing.T) {
+	path := writeTestZIP(t, map[string][]byte{
+		"backup/maps/dm_foo.bsp":         []byte("old copy"),
+		"valve_addon/maps/dm_foo.bsp":    []byte("real map"),
+		"valve_addon/models/tree.mdl":    []byte("mdl"),
+		"valve_addon/sound/foo/wind.wav": []byte("wav"),
+		"valve_addon/gfx/env/foo_bk.tga": []byte("tga"),
+	})
+
+	ma, err := hlds.ReadMapArchiveFromFile(path)
+	require.NoError(t, err)
+	defer ma.Close()
+
+	dir := t.TempDir()
+	_, err = ma.Extract(dir)
+	require.NoError(t, err)
+
+	bsp, err := os.ReadFile(filepath.Join(dir, "maps/dm_foo.bsp"))
+	require.NoError(t, err)
+	require.Equal(t, "real map", string(bsp), "the stray copy sorts first but has no resources")
+}
@@ -0,0 +1,233 @@
+package hlds
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/rs/zerolog/log"
+)
+
+var MatchInterruptedErr = errors.New("match ended before intermission")
+
+type PlayerScore struct {
+	Name   string
+	Frags  int
+	Deaths int
+}
+
+// Final scores of a match, sorted by frags then deaths.
+type Scoreboard struct {
+	Players []PlayerScore
+	Map     string
+}
+
+// Returns the players with the best score, more than one on a tie.
+func (board Scoreboard) Winners() []PlayerScore {
+	var ret []PlayerScore
+	for _, v := range board.Players {
+		if len(ret) > 0 && (v.Frags != ret[0].Frags || v.Deaths != ret[0].Deaths) {
+			break
+		}
+		ret = append(ret, v)
+	}
+
+	return ret
+}
+
+// Makes the server echo its logs on stdout so RunMatch can read them.
+func (cfg *ServerConfig) EnableMatch() {
+	cfg.logEcho = true
+}
+
+// Follows the logs of a server started with EnableMatch, restarts the map
+// once warmup is over and returns the scoreboard once the match map ends.
+// Returns MatchInterruptedErr if the server stopped before that.
+func (pool *Pool) RunMatch(ctx context.Context, id ServerID, warmup time.Duration) (Scoreboard, error) {
+	logs, err := pool.docker.ContainerLogs(ctx, id.String(), types.ContainerLogsOptions{
+		ShowStdout: true,
+		Follow:     true,
+		Since:      time.Now().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return Scoreboard{}, fmt.Errorf("unable to follow server logs: %w", err)
+	}
+	defer logs.Close()
+
+	r, w := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(w, io.Discard, logs)
+		w.CloseWithError(err)
+	}()
+
+	var (
+		tracker = newMatchTracker()
+		lines   = make(chan string)
+		readErr = make(chan error, 1)
+		done    = make(chan struct{})
+	)
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	warmupTimer := time.NewTimer(warmup)
+	defer warmupTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Scoreboard{}, ctx.Err()
+		case <-warmupTimer.C:
+			log.Info().Str("id", id.String()).Msg("Warmup over, restarting map.")
+			tracker.startRestart()
+			if _, err := pool.RCON(ctx, id, "restart"); err != nil {
+				return Scoreboard{}, fmt.Errorf("unable to end warmup: %w", err)
+			}
+		case line, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != nil && !errors.Is(err, context.Canceled) {
+					return Scoreboard{}, fmt.Errorf("unable to read server logs: %w", err)
+				}
+				return Scoreboard{}, MatchInterruptedErr
+			}
+
+			if tracker.feed(line) {
+				return tracker.scoreboard(), nil
+			}
+		}
+	}
+}
+
+type matchPhase int
+
+const (
+	matchPhaseWarmup matchPhase = iota
+	matchPhaseRestarting
+	matchPhaseLive
+)
+
+// Builds a scoreboard from HL log lines. Players are identified by their
+// userid, the last name seen is displayed.
+type matchTracker struct {
+	phase   matchPhase
+	mapName string
+	players map[string]*PlayerScore
+	order   []string // userids by first appearance, for stable ties
+}
+
+func newMatchTracker() *matchTracker {
+	return &matchTracker{players: make(map[string]*PlayerScore)}
+}
+
+func (tracker *matchTracker) startRestart() {
+	tracker.phase = matchPhaseRestarting
+}
+
+var (
+	// L 10/18/2026 - 12:34:56: message
+	logLinePrefixRegexp = regexp.MustCompile(`^L \d{2}/\d{2}/\d{4} - \d{2}:\d{2}:\d{2}: `)
+
+	// "Name<userid><authid><team>"
+	logPlayer           = `"(.*?)<(\d+)><[^>]*><[^>]*>"`
+	logKillRegexp       = regexp.MustCompile(`^` + logPlayer + ` killed ` + logPlayer + ` with "[^"]*"`)
+	logSuicideRegexp    = regexp.MustCompile(`^` + logPlayer + ` committed suicide with "[^"]*"`)
+	logEnteredRegexp    = regexp.MustCompile(`^` + logPlayer + ` entered the game`)
+	logNameRegexp       = regexp.MustCompile(`^` + logPlayer + ` changed name to "(.*)"`)
+	logStartedMapRegexp = regexp.MustCompile(`^Started map "([^"]*)"`)
+)
+
+// Returns true once the match is over.
+func (tracker *matchTracker) feed(line string) bool {
+	loc := logLinePrefixRegexp.FindStringIndex(line)
+	if loc == nil {
+		return false
+	}
+	msg := strings.TrimSpace(line[loc[1]:])
+
+	switch tracker.phase {
+	case matchPhaseWarmup:
+		return false
+	case matchPhaseRestarting:
+		if m := logStartedMapRegexp.FindStringSubmatch(msg); m != nil {
+			tracker.mapName = m[1]
+			tracker.phase = matchPhaseLive
+		}
+		return false
+	case matchPhaseLive:
+	}
+
+	// The engine closes the log file on map change, which happens right
+	// after intermission.
+	if msg == "Log file closed" {
+		return true
+	}
+
+	if m := logKillRegexp.FindStringSubmatch(msg); m != nil {
+		killer, victim := tracker.player(m[2], m[1]), tracker.player(m[4], m[3])
+		if killer == victim {
+			killer.Frags--
+		} else {
+			killer.Frags++
+		}
+		victim.Deaths++
+	} else if m := logSuicideRegexp.FindStringSubmatch(msg); m != nil {
+		player := tracker.player(m[2], m[1])
+		player.Frags--
+		player.Deaths++
+	} else if m := logEnteredRegexp.FindStringSubmatch(msg); m != nil {
+		tracker.player(m[2], m[1])
+	} else if m := logNameRegexp.FindStringSubmatch(msg); m != nil {
+		tracker.player(m[2], m[1]).Name = m[3]
+	}
+
+	return false
+}
+
+func (tracker *matchTracker) player(userID, name string) *PlayerScore {
+	player, ok := tracker.players[userID]
+	if !ok {
+		player = &PlayerScore{}
+		tracker.players[userID] = player
+		tracker.order = append(tracker.order, userID)
+	}
+	player.Name = name
+
+	return player
+}
+
+func (tracker *matchTracker) scoreboard() Scoreboard {
+	var ret = Scoreboard{
+		Map:     tracker.mapName,
+		Players: make([]PlayerScore, 0, len(tracker.order)),
+	}
+	for _, v := range tracker.order {
+		ret.Players = append(ret.Players, *tracker.players[v])
+	}
+
+	slices.SortStableFunc(ret.Players, func(a, b PlayerScore) int {
+		if a.Frags != b.Frags {
+			return b.Frags - a.Frags
+		}
+		return a.Deaths - b.Deaths
+	})
+
+	return ret
+}
@@ -0,0 +1,111 @@
+package hlds
+
+import (
+	"fmt"
+	"hldsbot/bsp"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type PlayabilitySeverity int
+
+const (
+	// The map can be played but something will feel off.
+	PlayabilityWarning PlayabilitySeverity = iota
+	// The map can't be played in deathmatch, only started on request.
+	PlayabilityFatal
+)
+
+type PlayabilityIssue struct {
+	Severity PlayabilitySeverity
+	Message  string
+}
+
+// Placed by mappers in deathmatch maps, they're not singleplayer hints.
+var deathmatchMonsters = []string{
+	"monster_furniture",
+	"monster_generic",
+	"monster_satchel",
+	"monster_tripmine",
+}
+
+// Checks the entity lump of a map for what makes it unfit for deathmatch on
+// a server with maxPlayers slots.
+func CheckPlayability(b *bsp.BSP, maxPlayers int) []PlayabilityIssue {
+	var (
+		spawns, starts, weapons int
+		monsters                = make(map[string]int)
+		changeLevels            int
+	)
+	for _, ent := range b.Entities {
+		className := strings.ToLower(ent.ClassName())
+		switch {
+		case className == "info_player_deathmatch":
+			spawns++
+		case className == "info_player_start":
+			starts++
+		case className == "trigger_changelevel":
+			changeLevels++
+		case strings.HasPrefix(className, "weapon_"),
+			className == "weaponbox",
+			className == "game_player_equip":
+			weapons++
+		case strings.HasPrefix(className, "monster_") && !slices.Contains(deathmatchMonsters, className):
+			monsters[className]++
+		}
+	}
+
+	var ret []PlayabilityIssue
+	add := func(severity PlayabilitySeverity, format string, args ...any) {
+		ret = append(ret, PlayabilityIssue{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case spawns == 0 && starts == 0:
+		add(PlayabilityFatal, "no info_player_deathmatch or info_player_start, players can't spawn")
+	case spawns == 0:
+		add(PlayabilityFatal, "no info_player_deathmatch, every player will spawn on the same info_player_start")
+	case spawns < maxPlayers:
+		add(PlayabilityWarning, "%d info_player_deathmatch for %d players, players may spawn on each other", spawns, maxPlayers)
+	}
+
+	if weapons == 0 {
+		add(PlayabilityWarning, "no weapons, players only have the crowbar and the glock")
+	}
+
+	if len(monsters) > 0 {
+		var (
+			names = make([]string, 0, len(monsters))
+			total int
+		)
+		for k, v := range monsters {
+			names = append(names, k)
+			total += v
+		}
+		slices.Sort(names)
+		add(PlayabilityWarning, "%d monsters (%s), the map may have been made for singleplayer", total, strings.Join(names, ", "))
+	}
+
+	if changeLevels > 0 {
+		add(PlayabilityWarning, "%d trigger_changelevel, ignored in multiplayer, the map may be part of a singleplayer campaign", changeLevels)
+	}
+
+	return ret
+}
+
+// Checks maps/<mapName>.bsp under baseDir, see CheckPlayability.
+func CheckMapPlayability(baseDir, mapName string, maxPlayers int) ([]PlayabilityIssue, error) {
+	b, err := bsp.Open(filepath.Join(baseDir, "maps", mapName+".bsp"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read map '%s': %w", mapName, err)
+	}
+
+	return CheckPlayability(b, maxPlayers), nil
+}
+
+func HasFatalPlayabilityIssue(issues []PlayabilityIssue) bool {
+	return slices.ContainsFunc(issues, func(issue PlayabilityIssue) bool {
+		return issue.Severity == PlayabilityFatal
+	})
+}
@@ -0,0 +1,88 @@
+package hlds_test
+
+import (
+	"hldsbot/bsp"
+	"hldsbot/hlds"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPlayability(t *testing.T) {
+	cases := map[string]struct {
+		entities string
+		fatal    bool
+		messages []string
+	}{
+		"playable": {
+			entities: `{"classname" "worldspawn"}
+{"classname" "info_player_deathmatch"}
+{"classname" "info_player_deathmatch"}
+{"classname" "weapon_crossbow"}
+{"classname" "monster_tripmine"}`,
+		},
+		"no spawns": {
+			entities: `{"classname" "worldspawn"}
+{"classname" "weapon_crossbow"}`,
+			fatal:    true,
+			messages: []string{"no info_player_deathmatch or info_player_start, players can't spawn"},
+		},
+		"singleplayer": {
+			entities: `{"classname" "worldspawn"}
+{"classname" "info_player_start"}
+{"classname" "monster_zombie"}
+{"classname" "monster_zombie"}
+{"classname" "monster_barney"}
+{"classname" "trigger_changelevel"}`,
+			fatal: true,
+			messages: []string{
+				"no info_player_deathmatch, every player will spawn on the same info_player_start",
+				"no weapons, players only have the crowbar and the glock",
+				"3 monsters (monster_barney, monster_zombie), the map may have been made for singleplayer",
+				"1 trigger_changelevel, ignored in multiplayer, the map may be part of a singleplayer campaign",
+			},
+		},
+		"few spawns": {
+			entities: `{"classname" "worldspawn"}
+{"classname" "info_player_deathmatch"}
+{"classname" "game_player_equip"}`,
+			messages: []string{"1 info_player_deathmatch for 2 players, players may spawn on each other"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			b, err := bsp.Parse(buildTestBSP(t, c.entities))
+			require.NoError(t, err)
+
+			issues := hlds.CheckPlayability(b, 2)
+			var messages []string
+			for _, v := range issues {
+				messages = append(messages, v.Message)
+			}
+			require.Equal(t, c.messages, messages)
+			require.Equal(t, c.fatal, hlds.HasFatalPlayabilityIssue(issues))
+		})
+	}
+
+	var entities = `{"classname" "worldspawn"}` + "\n" + `{"classname" "weapon_crossbow"}` + "\n"
+	for range 8 {
+		entities += `{"classname" "info_player_deathmatch"}` + "\n"
+	}
+	b, err := bsp.Parse(buildTestBSP(t, entities))
+	require.NoError(t, err)
+	require.Empty(t, hlds.CheckPlayability(b, 8), "a spawn per player slot is enough")
+	require.Len(t, hlds.CheckPlayability(b, 16), 1)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "maps"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "maps/foo.bsp"), buildTestBSP(t, cases["singleplayer"].entities), 0o644))
+	issues, err := hlds.CheckMapPlayability(dir, "foo", 2)
+	require.NoError(t, err)
+	require.True(t, hlds.HasFatalPlayabilityIssue(issues))
+
+	_, err = hlds.CheckMapPlayability(dir, "bar", 2)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,89 @@
+package hlds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+const pluginsMountDest = "/home/steam/hlds/plugins"
+
+var UnknownPluginBundleErr = errors.New("unknown plugin bundle")
+
+// Bundle names end up in container paths.
+var pluginBundleNameRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// A set of server-side plugins registered by the operator, eg. Metamod-P
+// plus some AMX Mod X plugins and their configs. A bundle is a directory
+// containing:
+//   - addons/: copied as-is inside valve/addons/
+//   - metamod.ini (optional): lines appended to addons/metamod/plugins.ini
+//   - amxx.ini (optional): lines appended to addons/amxmodx/configs/plugins.ini
+//
+// Bundles are mounted and installed by the entrypoint after the Vault content
+// has been copied, see docker/hlds.install-plugins.
+type PluginBundle struct {
+	Name string
+	Path string // absolute path on the host
+}
+
+// Reads bundles from the subdirectories of dir. A missing dir means no
+// bundles.
+func LoadPluginBundles(dir string) (map[string]PluginBundle, error) {
+	var ret = make(map[string]PluginBundle)
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to list plugin bundles: %w", err)
+	}
+
+	for _, v := range entries {
+		if !v.IsDir() {
+			continue
+		}
+
+		bundle, err := loadPluginBundle(filepath.Join(dir, v.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin bundle '%s': %w", v.Name(), err)
+		}
+
+		ret[bundle.Name] = bundle
+	}
+
+	return ret, nil
+}
+
+func loadPluginBundle(path string) (PluginBundle, error) {
+	var zero PluginBundle
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return zero, fmt.Errorf("unable to obtain absolute path: %w", err)
+	}
+
+	name := filepath.Base(abs)
+	if !pluginBundleNameRegexp.MatchString(name) {
+		return zero, fmt.Errorf("invalid name, must match %s", pluginBundleNameRegexp)
+	}
+
+	if info, err := os.Stat(filepath.Join(abs, "addons")); err != nil || !info.IsDir() {
+		return zero, errors.New("missing addons dir")
+	}
+
+	return PluginBundle{Name: name, Path: abs}, nil
+}
+
+func (bundle PluginBundle) mount() mount.Mount {
+	return mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   bundle.Path,
+		Target:   pluginsMountDest + "/" + bundle.Name,
+		ReadOnly: true,
+	}
+}
@@ -1,22 +1,29 @@
 package hlds
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"hldsbot/rcon"
 	"math"
 	"net"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	docker "github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/jackpal/gateway"
 	"github.com/rs/zerolog/log"
 )
 
+var ServerNotFoundErr = errors.New("no such server in pool")
+
 type AtCapacityError struct {
 	NextExpiry time.Time
 }
@@ -26,14 +33,19 @@ func (e *AtCapacityError) Error() string {
 }
 
 type Pool struct {
-	docker     *docker.Client
-	maxServers int
-	servers    map[ServerID]Server
+	docker       *docker.Client
+	maxServers   int
+	serversMutex sync.Mutex // never acquire portsMutex while holding it
+	servers      map[ServerID]Server
 
 	baseDownloadURL string
 	externalIP      net.IP
 	portsMutex      sync.Mutex
 	ports           []portAlloc // true = in use, false = free
+	hltvPorts       []portAlloc
+
+	onRemove func(ClosedServer)
+	store    *ContentStore
 }
 
 type portAlloc struct {
@@ -45,6 +57,7 @@ func NewPool(
 	dockerClient *docker.Client,
 	maxServers int,
 	minPort uint16,
+	minHLTVPort uint16,
 	baseDownloadURL string,
 ) (*Pool, error) {
 	// Let the OS throw when a bad port is bound, only do basic checks.
@@ -56,6 +69,14 @@ func NewPool(
 		return nil, errors.New("port overflow, minPort+maxServers > maxPort")
 	}
 
+	if int(minHLTVPort)+maxServers > math.MaxUint16 {
+		return nil, errors.New("port overflow, minHLTVPort+maxServers > maxPort")
+	}
+
+	if int(minPort) < int(minHLTVPort)+maxServers && int(minHLTVPort) < int(minPort)+maxServers {
+		return nil, errors.New("server and HLTV port ranges overlap")
+	}
+
 	externalIP, err := gateway.DiscoverInterface()
 	if err != nil {
 		return nil, fmt.Errorf("unable to detect default interface IP: %w", err)
@@ -66,8 +87,10 @@ func NewPool(
 		maxServers:      maxServers,
 		servers:         make(map[ServerID]Server, maxServers),
 		ports:           makePorts(minPort, maxServers),
+		hltvPorts:       makePorts(minHLTVPort, maxServers),
 		externalIP:      externalIP,
 		baseDownloadURL: baseDownloadURL,
+		store:           DefaultContentStore,
 	}, nil
 }
 
@@ -93,17 +116,31 @@ func (pool *Pool) AddServer(ctx context.Context, cfg ServerConfig) (Server, erro
 	}
 	name := fmt.Sprintf("hlds_%d", port)
 
+	// Only DownloadsDir is served, see Caddyfile.
+	downloadsDir, err := pool.publishDownloads(cfg.valveAddonDirPath)
+	if err != nil {
+		pool.FreePort(port)
+		return zero, fmt.Errorf("unable to publish downloads: %w", err)
+	}
+	// Kept once the server is added to the pool.
+	defer func() {
+		if err := pool.releaseDownloads(downloadsDir, ""); err != nil {
+			log.Error().Err(err).Msg("unable to remove published downloads")
+		}
+	}()
+
 	// HACK, I don't like writing over the config here but I have no better
 	// place to do it.
-	cfg.cvars["sv_downloadurl"] = pool.baseDownloadURL + strings.TrimPrefix(cfg.valveAddonDirPath, UserContentDir)
+	cfg.cvars["sv_downloadurl"] = pool.downloadURL(downloadsDir)
 	cfg.cvars["sv_allowdownload"] = "1"
 	cfg.cvars["sv_allowupload"] = "1"
 	log.Debug().Str("sv_downloadurl", cfg.cvars["sv_downloadurl"]).Msg("")
 
 	containerConfig := cfg.ContainerConfig(port)
-	hostConfig, tempFiles, err := cfg.HostConfig()
+	hostConfig := cfg.HostConfig()
+	configArchive, err := cfg.ConfigArchive()
 	if err != nil {
-		return zero, fmt.Errorf("unable to create host config: %w", err)
+		return zero, fmt.Errorf("unable to write server configuration: %w", err)
 	}
 
 	log.Info().Str("name", name).Msg("Creating container.")
@@ -113,6 +150,11 @@ func (pool *Pool) AddServer(ctx context.Context, cfg ServerConfig) (Server, erro
 	}
 	id := ServerID(res.ID)
 
+	if err := pool.docker.CopyToContainer(ctx, res.ID, configDir, configArchive, types.CopyToContainerOptions{}); err != nil {
+		pool.forceRemoveContainer(ctx, id)
+		return zero, fmt.Errorf("unable to copy configuration to container: %w", err)
+	}
+
 	log.Info().Str("name", name).Str("id", res.ID).Msg("Starting container.")
 	if err := pool.docker.ContainerStart(ctx, res.ID, types.ContainerStartOptions{}); err != nil {
 		pool.forceRemoveContainer(ctx, id)
@@ -124,11 +166,7 @@ func (pool *Pool) AddServer(ctx context.Context, cfg ServerConfig) (Server, erro
 	}
 
 	now := time.Now()
-	if _, ok := pool.servers[id]; ok {
-		return zero, fmt.Errorf("duplicate server id: %s", id)
-	}
-
-	pool.servers[id] = Server{
+	server := Server{
 		id:        id,
 		cfg:       cfg,
 		name:      name,
@@ -136,9 +174,31 @@ func (pool *Pool) AddServer(ctx context.Context, cfg ServerConfig) (Server, erro
 		hostIP:    pool.externalIP,
 		port:      port,
 		expiresAt: now.Add(cfg.lifetime),
-		tempFiles: tempFiles,
-		addonsDir: cfg.valveAddonDirPath,
+
+		addonsDir:    cfg.valveAddonDirPath,
+		downloadsDir: downloadsDir,
+	}
+
+	// The spectator proxy is a nice-to-have, don't fail the whole server
+	// if we can't get one.
+	if cfg.hltv {
+		if err := pool.addHLTV(ctx, &server); err != nil {
+			log.Error().Err(err).Str("id", id.String()).Msg("unable to start HLTV, continuing without it")
+		}
+	}
+
+	pool.serversMutex.Lock()
+	if _, ok := pool.servers[id]; ok {
+		pool.serversMutex.Unlock()
+		pool.forceRemoveContainer(ctx, id)
+		if server.hltvID != "" {
+			pool.forceRemoveContainer(ctx, server.hltvID)
+			pool.freeHLTVPort(server.hltvPort)
+		}
+		return zero, fmt.Errorf("duplicate server id: %s", id)
 	}
+	pool.servers[id] = server
+	pool.serversMutex.Unlock()
 
 	log.Info().
 		Uint16("port", port).
@@ -148,11 +208,20 @@ func (pool *Pool) AddServer(ctx context.Context, cfg ServerConfig) (Server, erro
 		Dur("lifetime", cfg.lifetime).
 		Msg("Server up and running.")
 
-	return pool.servers[id], nil
+	return server, nil
 }
 
+// Removing a server that is not in the pool, eg. removed concurrently by a
+// match ending and the Run loop, is a no-op.
 func (pool *Pool) RemoveServer(ctx context.Context, id ServerID) error {
-	server := pool.servers[id]
+	pool.serversMutex.Lock()
+	server, ok := pool.servers[id]
+	delete(pool.servers, id)
+	pool.serversMutex.Unlock()
+	if !ok {
+		return nil
+	}
+
 	log.Info().Str("id", id.String()).Str("name", server.name).Msg("removing server")
 
 	running, err := pool.IsServerRunning(ctx, id)
@@ -163,18 +232,103 @@ func (pool *Pool) RemoveServer(ctx context.Context, id ServerID) error {
 		}
 	}
 
+	// Stop before the server goes away, HLTV would start retrying and
+	// potentially overwrite things.
+	if server.recordingDir != "" {
+		pool.stopRecording(ctx, server)
+	}
+
 	if running {
 		pool.forceRemoveContainer(ctx, server.id)
 	}
 
-	defer delete(pool.servers, id)
+	if server.hltvID != "" {
+		pool.forceRemoveContainer(ctx, server.hltvID)
+		pool.freeHLTVPort(server.hltvPort)
+	}
 
 	pool.FreePort(server.port)
 
+	closed := ClosedServer{ID: id, MapCycle: server.cfg.mapCycle}
+	if server.recordingDir != "" {
+		closed.DemoURLs, closed.DemosExpireAt, err = pool.publishDemos(server.recordingDir)
+		if err != nil {
+			log.Error().Err(err).Str("id", id.String()).Msg("unable to publish demos")
+		}
+	}
+
+	if pool.onRemove != nil {
+		defer pool.onRemove(closed)
+	}
+
+	if err := pool.releaseDownloads(server.downloadsDir, id); err != nil {
+		log.Error().Err(err).Str("id", id.String()).Msg("unable to remove published downloads")
+	}
+
 	if err := server.Close(); err != nil {
 		return fmt.Errorf("unable to close server: %w", err)
 	}
 
+	if err := pool.store.CollectGarbage(); err != nil {
+		log.Error().Err(err).Msg("unable to collect unused content")
+	}
+
+	return nil
+}
+
+// Starts an HLTV proxy connected to the given server and registers it to the
+// server.
+func (pool *Pool) addHLTV(ctx context.Context, server *Server) error {
+	port, err := pool.allocHLTVPort()
+	if err != nil {
+		return fmt.Errorf("unable to allocate HLTV port: %w", err)
+	}
+	name := fmt.Sprintf("hltv_%d", port)
+
+	containerConfig := server.cfg.HLTVContainerConfig(port, server.port)
+	hostConfig := container.HostConfig{
+		NetworkMode: "host",
+		AutoRemove:  true,
+	}
+
+	var recordingDir string
+	if server.cfg.recordDemos {
+		recordingDir, err = makeRecordingDir(UserContentDir)
+		if err != nil {
+			pool.freeHLTVPort(port)
+			return err
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, recordingMount(recordingDir))
+	}
+
+	cleanup := func() {
+		pool.freeHLTVPort(port)
+		if recordingDir != "" {
+			if err := os.RemoveAll(recordingDir); err != nil {
+				log.Error().Err(err).Msg("unable to remove recording dir")
+			}
+		}
+	}
+
+	log.Info().Str("name", name).Msg("Creating HLTV container.")
+	res, err := pool.docker.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, name)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("unable to create HLTV container: %w", err)
+	}
+	id := ServerID(res.ID)
+
+	log.Info().Str("name", name).Str("id", res.ID).Msg("Starting HLTV container.")
+	if err := pool.docker.ContainerStart(ctx, res.ID, types.ContainerStartOptions{}); err != nil {
+		pool.forceRemoveContainer(ctx, id)
+		cleanup()
+		return fmt.Errorf("unable to start HLTV container: %w", err)
+	}
+
+	server.hltvID = id
+	server.hltvPort = port
+	server.recordingDir = recordingDir
+
 	return nil
 }
 
@@ -191,17 +345,11 @@ func (pool *Pool) AllocPort() (uint16, error) {
 	pool.portsMutex.Lock()
 	defer pool.portsMutex.Unlock()
 
-	for i, v := range pool.ports {
-		if v.inUse {
-			continue
-		}
-
-		log.Debug().Uint16("port", v.port).Msg("Allocating port.")
-		pool.ports[i].inUse = true
-		return v.port, nil
+	if port, ok := allocPortFrom(pool.ports); ok {
+		return port, nil
 	}
 
-	if nextExpiry, ok := pool.getNextServerExpiry(); ok {
+	if nextExpiry, ok := pool.getNextServerExpiry(); ok { // takes serversMutex
 		return 0, &AtCapacityError{NextExpiry: nextExpiry}
 	}
 
@@ -212,18 +360,66 @@ func (pool *Pool) FreePort(port uint16) {
 	pool.portsMutex.Lock()
 	defer pool.portsMutex.Unlock()
 
-	for i, v := range pool.ports {
+	freePortFrom(pool.ports, port)
+}
+
+// There are as many HLTV ports as server ports, if we got a server port we
+// should always get an HLTV port.
+func (pool *Pool) allocHLTVPort() (uint16, error) {
+	pool.portsMutex.Lock()
+	defer pool.portsMutex.Unlock()
+
+	if port, ok := allocPortFrom(pool.hltvPorts); ok {
+		return port, nil
+	}
+
+	return 0, errors.New("all HLTV ports allocated")
+}
+
+func (pool *Pool) freeHLTVPort(port uint16) {
+	pool.portsMutex.Lock()
+	defer pool.portsMutex.Unlock()
+
+	freePortFrom(pool.hltvPorts, port)
+}
+
+// Caller must hold portsMutex.
+func allocPortFrom(ports []portAlloc) (uint16, bool) {
+	for i, v := range ports {
+		if v.inUse {
+			continue
+		}
+
+		log.Debug().Uint16("port", v.port).Msg("Allocating port.")
+		ports[i].inUse = true
+		return v.port, true
+	}
+
+	return 0, false
+}
+
+// Caller must hold portsMutex.
+func freePortFrom(ports []portAlloc, port uint16) {
+	for i, v := range ports {
 		if v.port != port {
 			continue
 		}
 
 		log.Debug().Uint16("port", v.port).Msg("Freeing port.")
-		pool.ports[i].inUse = false
+		ports[i].inUse = false
 		return
 	}
 }
 
 func (pool *Pool) Run(ctx context.Context) error {
+	// Cleanup whatever was left behind by a previous run.
+	if err := os.RemoveAll(DownloadsDir); err != nil {
+		log.Error().Err(err).Msg("unable to remove published downloads")
+	}
+	if err := pool.store.CollectGarbage(); err != nil {
+		log.Error().Err(err).Msg("unable to collect unused content")
+	}
+
 	timer := time.NewTicker(5 * time.Second)
 	defer timer.Stop()
 loop:
@@ -238,6 +434,9 @@ loop:
 			if err := pool.removeStoppedServers(ctx); err != nil {
 				return fmt.Errorf("unable to remove stopped servers: %w", err)
 			}
+			if err := pool.removeExpiredDemos(); err != nil {
+				log.Error().Err(err).Msg("unable to remove expired demos")
+			}
 		case <-ctx.Done():
 			break loop
 		}
@@ -252,7 +451,7 @@ func (pool *Pool) removeExpiredServers(ctx context.Context) error {
 	now := time.Now()
 	var errs []error
 
-	for _, v := range pool.servers {
+	for _, v := range pool.listServers() {
 		if now.Before(v.expiresAt) {
 			continue
 		}
@@ -266,10 +465,11 @@ func (pool *Pool) removeExpiredServers(ctx context.Context) error {
 }
 
 func (pool *Pool) close(ctx context.Context) error {
-	var errs = make([]error, 0, len(pool.servers))
-	for id := range pool.servers {
-		if err := pool.RemoveServer(ctx, id); err != nil {
-			errs = append(errs, fmt.Errorf("unable to remove server %s: %w", id, err))
+	servers := pool.listServers()
+	var errs = make([]error, 0, len(servers))
+	for _, v := range servers {
+		if err := pool.RemoveServer(ctx, v.id); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove server %s: %w", v.id, err))
 		}
 	}
 
@@ -282,7 +482,7 @@ func (pool *Pool) removeStoppedServers(ctx context.Context) error {
 		now  = time.Now()
 	)
 
-	for _, v := range pool.servers {
+	for _, v := range pool.listServers() {
 		ok, err := pool.IsServerRunning(ctx, v.id)
 		if isDockerErrNotFound(err) {
 			log.Warn().
@@ -340,9 +540,199 @@ func isDockerErrNotFound(err error) bool {
 	}
 }
 
+func (pool *Pool) GetServer(id ServerID) (Server, bool) {
+	pool.serversMutex.Lock()
+	defer pool.serversMutex.Unlock()
+
+	server, ok := pool.servers[id]
+	return server, ok
+}
+
+// Returns a copy of the servers in the pool, they may be removed
+// concurrently while the caller iterates.
+func (pool *Pool) listServers() []Server {
+	pool.serversMutex.Lock()
+	defer pool.serversMutex.Unlock()
+
+	ret := make([]Server, 0, len(pool.servers))
+	for _, v := range pool.servers {
+		ret = append(ret, v)
+	}
+
+	return ret
+}
+
+// Executes a command on the server using its rcon_password and returns the
+// console output.
+func (pool *Pool) RCON(ctx context.Context, id ServerID, command string) (string, error) {
+	server, ok := pool.GetServer(id)
+	if !ok {
+		return "", ServerNotFoundErr
+	}
+
+	password := server.CVar("rcon_password")
+	out, err := rcon.NewClient(server.Host(), password).Exec(ctx, command)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute rcon command on server %s: %w", id, err)
+	}
+
+	// Output is shown to users, querying the cvar or cvarlist would leak it.
+	return strings.ReplaceAll(out, password, "<redacted>"), nil
+}
+
+// Switches a running server to a new map without restarting it, players stay
+// connected and the server expiry is kept.
+// The contents of addonsDir are moved into the server's own addons dir, the
+// same rules as ServerConfig.valveAddonDirPath apply. addonsDir is removed
+// once this returns, whether it succeeded or not.
+// An empty branding hostname is replaced by the default one for the new map.
+func (pool *Pool) ChangeLevel(
+	ctx context.Context,
+	id ServerID,
+	addonsDir, mapName string,
+	branding Branding,
+) error {
+	absAddonsDir, err := resolveAddonDirPath(addonsDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve path to new addons dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(absAddonsDir); err != nil {
+			log.Error().Err(err).Str("path", absAddonsDir).Msg("unable to remove new addons dir")
+		}
+	}()
+
+	server, ok := pool.GetServer(id)
+	if !ok {
+		return ServerNotFoundErr
+	}
+
+	if !isValidMapName(mapName) {
+		return fmt.Errorf("invalid map name: '%s'", mapName)
+	}
+
+	if branding.Hostname == "" {
+		branding.Hostname = defaultHostname(mapName)
+	}
+	if err := branding.validate(); err != nil {
+		return fmt.Errorf("invalid branding: %w", err)
+	}
+
+	log.Info().Str("id", id.String()).Str("map", mapName).Msg("Changing level.")
+	if err := mergeAddonDir(absAddonsDir, server.addonsDir); err != nil {
+		return fmt.Errorf("unable to merge new content into server addons dir: %w", err)
+	}
+
+	if err := pool.exec(ctx, id, syncAddonCmd); err != nil {
+		return fmt.Errorf("unable to copy new content inside the container: %w", err)
+	}
+
+	downloadsDir, err := pool.publishDownloads(server.addonsDir)
+	if err != nil {
+		return fmt.Errorf("unable to publish new content: %w", err)
+	}
+	// Kept once the server is updated below.
+	defer func() {
+		if err := pool.releaseDownloads(downloadsDir, ""); err != nil {
+			log.Error().Err(err).Msg("unable to remove published downloads")
+		}
+	}()
+
+	// The game only reads the map cycle again when mapcyclefile changes,
+	// rewriting mapcycle.txt would go unnoticed and the server would go
+	// back to the previous map once this one ends.
+	var (
+		mapCycleFile = "mapcycle_" + mapName + ".txt"
+		files        = map[string][]byte{mapCycleFile: []byte(mapName + "\n")}
+	)
+	if branding.MOTD != "" {
+		files["motd.txt"] = []byte(branding.MOTD)
+	}
+
+	configArchive, err := tarFiles(files)
+	if err != nil {
+		return fmt.Errorf("unable to write server configuration: %w", err)
+	}
+
+	if err := pool.docker.CopyToContainer(ctx, id.String(), configDir, configArchive, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("unable to copy configuration to container: %w", err)
+	}
+
+	if _, err := pool.RCON(ctx, id, fmt.Sprintf(`hostname "%s"`, branding.Hostname)); err != nil {
+		return fmt.Errorf("unable to set hostname: %w", err)
+	}
+	if _, err := pool.RCON(ctx, id, fmt.Sprintf(`mapcyclefile "%s"`, mapCycleFile)); err != nil {
+		return fmt.Errorf("unable to set map cycle: %w", err)
+	}
+	if _, err := pool.RCON(ctx, id, fmt.Sprintf(`sv_downloadurl "%s"`, pool.downloadURL(downloadsDir))); err != nil {
+		return fmt.Errorf("unable to set download URL: %w", err)
+	}
+	if _, err := pool.RCON(ctx, id, "changelevel "+mapName); err != nil {
+		return fmt.Errorf("unable to change level: %w", err)
+	}
+
+	if server.downloadsDir != downloadsDir {
+		if err := pool.releaseDownloads(server.downloadsDir, id); err != nil {
+			log.Error().Err(err).Str("id", id.String()).Msg("unable to remove published downloads")
+		}
+	}
+
+	server.cfg.mapCycle = []string{mapName}
+	server.cfg.cvars["hostname"] = branding.Hostname
+	server.cfg.cvars["sv_downloadurl"] = pool.downloadURL(downloadsDir)
+	server.cfg.motd = branding.MOTD
+	server.downloadsDir = downloadsDir
+
+	// The server may have been removed while the level was changing.
+	pool.serversMutex.Lock()
+	defer pool.serversMutex.Unlock()
+	if _, ok := pool.servers[id]; !ok {
+		return ServerNotFoundErr
+	}
+	pool.servers[id] = server
+
+	return nil
+}
+
+// Runs a command inside the server container and waits for it to exit.
+func (pool *Pool) exec(ctx context.Context, id ServerID, cmd []string) error {
+	res, err := pool.docker.ContainerExecCreate(ctx, id.String(), types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create exec: %w", err)
+	}
+
+	attach, err := pool.docker.ContainerExecAttach(ctx, res.ID, types.ExecStartCheck{})
+	if err != nil {
+		return fmt.Errorf("unable to attach to exec: %w", err)
+	}
+	defer attach.Close()
+
+	// Wait for the command to terminate.
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attach.Reader); err != nil {
+		return fmt.Errorf("unable to read exec output: %w", err)
+	}
+
+	inspect, err := pool.docker.ContainerExecInspect(ctx, res.ID)
+	if err != nil {
+		return fmt.Errorf("unable to inspect exec: %w", err)
+	}
+
+	if inspect.ExitCode != 0 {
+		log.Debug().Strs("cmd", cmd).Str("output", output.String()).Msg("exec failed")
+		return fmt.Errorf("command %v exited with code %d", cmd, inspect.ExitCode)
+	}
+
+	return nil
+}
+
 func (pool *Pool) getNextServerExpiry() (time.Time, bool) {
 	var min time.Time
-	for _, v := range pool.servers {
+	for _, v := range pool.listServers() {
 		if min.IsZero() || v.expiresAt.Before(min) {
 			min = v.expiresAt
 		}
@@ -9,7 +9,7 @@ import (
 
 func TestPortAlloc(t *testing.T) {
 	var min uint16 = 27015
-	pool, err := hlds.NewPool(nil, 2, 27015, "https://localhost")
+	pool, err := hlds.NewPool(nil, 2, 27015, 27020, "https://localhost")
 	require.NoError(t, err)
 
 	port, err := pool.AllocPort()
@@ -30,3 +30,8 @@ func TestPortAlloc(t *testing.T) {
 	require.GreaterOrEqual(t, port, min, "port allocated within bounds")
 	require.Equal(t, port, port3, "re-alloacted free'd port")
 }
+
+func TestPortRangesOverlap(t *testing.T) {
+	_, err := hlds.NewPool(nil, 10, 27015, 27020, "https://localhost")
+	require.Error(t, err, "overlapping port ranges are rejected")
+}
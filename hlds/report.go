@@ -0,0 +1,120 @@
+package hlds
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// What happened to an archive entry.
+type EntryOutcome string
+
+const (
+	OutcomeKept           EntryOutcome = "kept"
+	OutcomeGarbage        EntryOutcome = "garbage"         // OS metadata, eg. __MACOSX
+	OutcomeInvalidName    EntryOutcome = "invalid_name"    // undecodable or escaping the archive
+	OutcomeOutsidePrefix  EntryOutcome = "outside_prefix"  // not under the dir holding maps/
+	OutcomeDuplicate      EntryOutcome = "duplicate"       // another entry has the same destination
+	OutcomeDisallowedType EntryOutcome = "disallowed_type" // not something a map can ship
+	OutcomeCaseCollision  EntryOutcome = "case_collision"  // see PathCollision
+	OutcomeNotSelected    EntryOutcome = "not_selected"    // BSP or config of a map not picked
+	OutcomeStockCopy      EntryOutcome = "stock_copy"      // identical to a stock file
+	OutcomeStockOverride  EntryOutcome = "stock_override"  // would replace a stock file
+)
+
+type ReportEntry struct {
+	Path    string       `json:"path"`
+	Outcome EntryOutcome `json:"outcome"`
+	Dest    string       `json:"dest,omitempty"`   // path relative to valve/ when kept
+	Detail  string       `json:"detail,omitempty"` // eg. the entry that was kept instead
+}
+
+// Outcome of every entry of the archive, for mappers to fix their packaging.
+type ExtractionReport struct {
+	NestedArchive string        `json:"nested_archive,omitempty"`
+	Entries       []ReportEntry `json:"entries"`
+	Warnings      []string      `json:"warnings,omitempty"`
+
+	index  map[string]int // path => index in Entries
+	byDest map[string]int // dest of kept entries => index in Entries
+}
+
+func newExtractionReport() *ExtractionReport {
+	return &ExtractionReport{
+		index:  make(map[string]int),
+		byDest: make(map[string]int),
+	}
+}
+
+// Records the outcome of an entry, replacing the previous one.
+func (report *ExtractionReport) set(path string, outcome EntryOutcome, dest, detail string) {
+	entry := ReportEntry{Path: path, Outcome: outcome, Dest: dest, Detail: detail}
+	i, ok := report.index[path]
+	if ok {
+		delete(report.byDest, report.Entries[i].Dest)
+		report.Entries[i] = entry
+	} else {
+		i = len(report.Entries)
+		report.index[path] = i
+		report.Entries = append(report.Entries, entry)
+	}
+
+	if outcome == OutcomeKept {
+		report.byDest[dest] = i
+	}
+}
+
+// Updates the destination of a kept entry after it was renamed.
+func (report *ExtractionReport) renameDest(from, to string) {
+	i, ok := report.byDest[from]
+	if !ok {
+		return
+	}
+
+	delete(report.byDest, from)
+	report.Entries[i].Dest = to
+	report.byDest[to] = i
+}
+
+func (report *ExtractionReport) warn(format string, args ...any) {
+	report.Warnings = append(report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Returns a copy with entries sorted by path.
+func (report ExtractionReport) clone() ExtractionReport {
+	var ret = ExtractionReport{
+		NestedArchive: report.NestedArchive,
+		Entries:       slices.Clone(report.Entries),
+		Warnings:      slices.Clone(report.Warnings),
+	}
+	slices.SortFunc(ret.Entries, func(a, b ReportEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return ret
+}
+
+// Number of entries by outcome.
+func (report ExtractionReport) Counts() map[EntryOutcome]int {
+	var ret = make(map[EntryOutcome]int)
+	for _, v := range report.Entries {
+		ret[v.Outcome]++
+	}
+
+	return ret
+}
+
+// Whether anything was dropped or looked wrong.
+func (report ExtractionReport) HasIssues() bool {
+	if len(report.Warnings) > 0 {
+		return true
+	}
+
+	for _, v := range report.Entries {
+		if v.Outcome != OutcomeKept && v.Outcome != OutcomeNotSelected && v.Outcome != OutcomeGarbage {
+			return true
+		}
+	}
+
+	return false
+}
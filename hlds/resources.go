@@ -0,0 +1,259 @@
+package hlds
+
+import (
+	"fmt"
+	"hldsbot/bsp"
+	"hldsbot/overview"
+	"hldsbot/wad"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Files a map needs on top of its BSP, resolved from its entity lump.
+// Extracted files are renamed to the case used by the map to reference them.
+type MapResources struct {
+	Files   []string // referenced and present in the archive, written to the .res
+	Missing []string // referenced but absent from the archive, may be stock content
+
+	// Textures not embedded in the BSP and absent from its WADs. Clients
+	// disconnect when a texture can't be found.
+	MissingTextures []string
+	// Stock WADs used by the map whose textures are not listed in the stock
+	// textures, MissingTextures can't be trusted when set.
+	UnknownStockWADs []string
+}
+
+// WADs shipped with Half-Life in valve/, maps can reference them without
+// bundling them.
+var StockWADs = []string{
+	"cached.wad",
+	"decals.wad",
+	"fonts.wad",
+	"gfx.wad",
+	"halflife.wad",
+	"liquids.wad",
+	"spraypaint.wad",
+	"tempdecal.wad",
+	"xeno.wad",
+}
+
+var skySides = []string{"up", "dn", "lf", "rt", "ft", "bk"}
+
+// Returns the paths relative to valve/ of every file referenced by the map
+// entities, sorted. Paths keep the case used by the map, the first one wins
+// when a file is referenced using different cases.
+func referencedFiles(b *bsp.BSP) []string {
+	var ret []string
+	add := func(v string) {
+		v = strings.ReplaceAll(v, `\`, "/")
+		if v != "" && !slices.ContainsFunc(ret, func(other string) bool {
+			return strings.EqualFold(v, other)
+		}) {
+			ret = append(ret, v)
+		}
+	}
+
+	if world, ok := b.Worldspawn(); ok {
+		// Absolute paths from the mapper's machine, only the name matters.
+		for _, v := range strings.Split(world["wad"], ";") {
+			if v = strings.TrimSpace(strings.ReplaceAll(v, `\`, "/")); v != "" {
+				add(path.Base(v))
+			}
+		}
+
+		if sky := strings.TrimSpace(world["skyname"]); sky != "" {
+			for _, side := range skySides {
+				add("gfx/env/" + sky + side + ".tga")
+			}
+		}
+	}
+
+	// model, message, noise* and the like, but any key can hold a path (eg.
+	// env_beam texture, env_shooter shootmodel), go by extension.
+	for _, ent := range b.Entities {
+		for _, v := range ent {
+			if ref, ok := entityResource(v); ok {
+				add(ref)
+			}
+		}
+	}
+
+	slices.SortFunc(ret, func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+
+	return ret
+}
+
+func entityResource(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(path.Ext(value)) {
+	case ".mdl", ".spr":
+		return value, true
+	case ".wav":
+		// Sentences (!NAME) are not files. Sounds can be prefixed by
+		// channel modifiers and are relative to sound/.
+		if strings.HasPrefix(value, "!") {
+			return "", false
+		}
+		value = strings.TrimLeft(value, "*#@")
+		if !strings.HasPrefix(strings.ToLower(value), "sound/") {
+			value = "sound/" + value
+		}
+		return value, true
+	}
+
+	return "", false
+}
+
+// Cross-checks the files referenced by the map with the extracted files,
+// both are compared case-insensitively. Files contains the extracted names,
+// the returned map gives the name each file should be renamed to when the
+// map uses another case.
+func resolveResources(referenced, extracted []string, stock *StockManifest) (MapResources, map[string]string) {
+	var byLowerName = make(map[string]string, len(extracted))
+	for _, v := range extracted {
+		byLowerName[strings.ToLower(v)] = v
+	}
+
+	var (
+		ret     MapResources
+		renames = make(map[string]string)
+	)
+	for _, v := range referenced {
+		if actual, ok := byLowerName[strings.ToLower(v)]; ok {
+			ret.Files = append(ret.Files, actual)
+			if actual != v {
+				renames[actual] = v
+			}
+		} else if !isStockWAD(v) && !stock.Has(v) {
+			ret.Missing = append(ret.Missing, v)
+		}
+	}
+
+	return ret, renames
+}
+
+func isStockWAD(name string) bool {
+	return slices.ContainsFunc(StockWADs, func(v string) bool {
+		return strings.EqualFold(v, name)
+	})
+}
+
+// Returns the names of the textures used by the map that are neither
+// embedded nor found in the given WADs or in the stock WADs it references,
+// and the stock WADs missing from stockTextures. Textures can't be verified
+// when a stock WAD is unknown, none are returned.
+func missingTextures(b *bsp.BSP, wads []*wad.WAD, stockWADs []string, stockTextures *StockTextures) ([]string, []string) {
+	var unknown []string
+	for _, v := range stockWADs {
+		if !stockTextures.Knows(v) {
+			unknown = append(unknown, v)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, unknown
+	}
+
+	var ret []string
+	for _, tex := range b.Textures {
+		if tex.Missing || tex.Embedded() {
+			continue
+		}
+
+		found := slices.ContainsFunc(wads, func(w *wad.WAD) bool {
+			return w.HasTexture(tex.Name)
+		}) || slices.ContainsFunc(stockWADs, func(v string) bool {
+			return stockTextures.Has(v, tex.Name)
+		})
+		name := strings.ToLower(tex.Name)
+		if !found && !slices.Contains(ret, name) {
+			ret = append(ret, name)
+		}
+	}
+	slices.Sort(ret)
+
+	return ret, nil
+}
+
+// Reads the WADs referenced by the map that are part of the archive.
+func readMapWADs(baseDir string, resources MapResources) []*wad.WAD {
+	var ret []*wad.WAD
+	for _, v := range resources.Files {
+		if !strings.EqualFold(path.Ext(v), ".wad") {
+			continue
+		}
+
+		w, err := wad.Open(filepath.Join(baseDir, v))
+		if err != nil {
+			log.Warn().Err(err).Str("wad", v).Msg("unable to read WAD")
+			continue
+		}
+		ret = append(ret, w)
+	}
+
+	return ret
+}
+
+// Returns the resources of the map, with Files named as extracted, and the
+// renames needed to match the case used by the map, see resolveResources.
+func readMapResources(
+	baseDir string,
+	b *bsp.BSP,
+	extracted []string,
+	stock *StockManifest,
+	stockTextures *StockTextures,
+) (MapResources, map[string]string) {
+	var (
+		referenced         = referencedFiles(b)
+		resources, renames = resolveResources(referenced, extracted, stock)
+		stockWADs          []string
+	)
+	for _, v := range referenced {
+		// Bundled copies are read with the other WADs of the archive.
+		if isStockWAD(v) && !slices.ContainsFunc(resources.Files, func(file string) bool {
+			return strings.EqualFold(file, v)
+		}) {
+			stockWADs = append(stockWADs, v)
+		}
+	}
+	resources.MissingTextures, resources.UnknownStockWADs = missingTextures(b, readMapWADs(baseDir, resources), stockWADs, stockTextures)
+
+	return resources, renames
+}
+
+// Generates the HLTV overview of a map unless the archive has one, returns
+// the paths of the generated files.
+func writeOverview(baseDir, mapName string, b *bsp.BSP, extracted []string) []string {
+	if slices.ContainsFunc(extracted, func(v string) bool {
+		return strings.EqualFold(v, path.Join("overviews", mapName+".txt")) ||
+			strings.EqualFold(v, path.Join("overviews", mapName+".bmp"))
+	}) {
+		return nil
+	}
+
+	files, err := overview.WriteHLTV(baseDir, mapName, b)
+	if err != nil {
+		log.Warn().Err(err).Str("map", mapName).Msg("unable to generate overview")
+	}
+
+	return files
+}
+
+func renameExtracted(baseDir, from, to string) error {
+	dst := filepath.Join(baseDir, to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("unable to create dir: %w", err)
+	}
+
+	if err := os.Rename(filepath.Join(baseDir, from), dst); err != nil {
+		return fmt.Errorf("unable to rename '%s' to '%s': %w", from, to, err)
+	}
+
+	return nil
+}
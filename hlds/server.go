@@ -1,14 +1,18 @@
 package hlds
 
 import (
+	"archive/tar"
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -29,12 +33,18 @@ const (
 	HLDSDockerImage = "hlds:latest"
 )
 
+const hltvEntrypoint = "/usr/bin/hltv.entrypoint"
+
 const (
 	valveAddonMountDest = "/home/steam/hlds/valve_addon"
-	instanceCfgDest     = "/home/steam/hlds/valve/instance.cfg"
-	mapCycleDest        = "/home/steam/hlds/valve/mapcycle.txt"
+	configDir           = "/home/steam/hlds/valve"
+
+	containerUID = 1000 // steam user in the HLDS image
 )
 
+// Copies valve_addon contents inside valve/, see docker/hlds.sync-addon.
+var syncAddonCmd = []string{"/usr/bin/hlds.sync-addon"}
+
 type ServerID string
 
 func (id ServerID) String() string {
@@ -52,6 +62,11 @@ func isValidCVarRune(r rune) bool {
 		return false
 	}
 
+	// Newlines would allow injecting arbitrary commands in the cfg.
+	if unicode.IsControl(r) {
+		return false
+	}
+
 	// Disallow quotes and quote the strings ourselves.
 	// There's no escaping in goldsrc cvars and there's a bug that leads to
 	// poorly bounded reads if you attempt something like: echo "abcde
@@ -68,13 +83,18 @@ func isStringValidCVar(s string) bool {
 	return true
 }
 
+// Keys are written in order to get a stable output.
 func (cvars CVars) Write(w io.Writer) error {
-	for k, v := range cvars {
-		if !isStringValidCVar(k) {
-			return fmt.Errorf("invalid key in cvar: '%s'", k)
-		}
-		if !isStringValidCVar(k) {
-			return fmt.Errorf("invalid value in cvar '%s': '%s'", k, v)
+	keys := make([]string, 0, len(cvars))
+	for k := range cvars {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		v := cvars[k]
+		if err := ValidateCVar(k, v); err != nil {
+			return err
 		}
 
 		if _, err := fmt.Fprintf(w, `"%s" "%s"`+"\n", k, v); err != nil {
@@ -98,7 +118,47 @@ type ServerConfig struct {
 	lifetime   time.Duration
 	maxPlayers int      // 2-32, we don't want to run singleplayer servers.
 	mapCycle   []string // first entry as startup map
-	cvars      CVars    // ends up in instance.cfg called by server.cfg
+
+	// Both end up in instance.cfg called by server.cfg, layers first in
+	// order, then the cvars generated by HLDSBot.
+	layers []CVarLayer
+	cvars  CVars
+
+	hltv        bool // start an HLTV proxy alongside the server
+	recordDemos bool // have the HLTV proxy record demos, implies hltv
+	logEcho     bool // echo logs on stdout for RunMatch
+
+	plugins []PluginBundle
+	motd    string // written to motd.txt if not empty
+}
+
+// Server-specific texts shown to players, rendered by the caller. Empty fields
+// keep the defaults.
+type Branding struct {
+	Hostname string
+	MOTD     string
+}
+
+// The engine truncates anything longer.
+const (
+	MaxHostnameLength = 63
+	MaxMOTDLength     = 1536
+)
+
+func (b Branding) validate() error {
+	if len(b.Hostname) > MaxHostnameLength {
+		return fmt.Errorf("hostname longer than %d characters", MaxHostnameLength)
+	}
+
+	if err := ValidateCVar("hostname", b.Hostname); err != nil {
+		return err
+	}
+
+	if len(b.MOTD) > MaxMOTDLength {
+		return fmt.Errorf("MOTD longer than %d characters", MaxMOTDLength)
+	}
+
+	return nil
 }
 
 type Server struct {
@@ -110,16 +170,38 @@ type Server struct {
 	startedAt time.Time
 	expiresAt time.Time
 
-	tempFiles []string // files to remove after closing the server
-	addonsDir string
+	addonsDir    string
+	downloadsDir string // served as sv_downloadurl, see Pool.publishDownloads
+
+	hltvID       ServerID // HLTV container, empty if HLTV is not running
+	hltvPort     uint16
+	recordingDir string // where HLTV writes demos, empty if not recording
+}
+
+func (s Server) ID() ServerID {
+	return s.id
 }
 
 func (s Server) Host() string {
 	return net.JoinHostPort(s.hostIP.String(), strconv.Itoa(int(s.port)))
 }
 
+// Returns the address spectators can connect to, if the server has an HLTV
+// proxy. Spectators use the same password as players.
+func (s Server) HLTVHost() (string, bool) {
+	if s.hltvID == "" {
+		return "", false
+	}
+
+	return net.JoinHostPort(s.hostIP.String(), strconv.Itoa(int(s.hltvPort))), true
+}
+
 func (s Server) CVar(key string) string {
-	return s.cfg.cvars[key]
+	return s.cfg.CVar(key)
+}
+
+func (s Server) MaxPlayers() int {
+	return s.cfg.maxPlayers
 }
 
 func (s Server) ExpiresAt() time.Time {
@@ -127,12 +209,12 @@ func (s Server) ExpiresAt() time.Time {
 }
 
 func (s *Server) Close() error {
-	var errs = make([]error, 0, len(s.tempFiles))
+	var errs = make([]error, 0, 2)
 
-	for _, path := range s.tempFiles {
-		log.Debug().Str("path", path).Msg("removing file")
-		if err := os.Remove(path); err != nil {
-			errs = append(errs, fmt.Errorf("unable to remove temp file '%s': %w", path, err))
+	if s.recordingDir != "" && strings.HasPrefix(s.recordingDir, UserContentDir) {
+		log.Debug().Str("path", s.recordingDir).Msg("removing dir")
+		if err := os.RemoveAll(s.recordingDir); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove recording dir: %w", err))
 		}
 	}
 
@@ -147,12 +229,13 @@ func (s *Server) Close() error {
 }
 
 // Server sv_password and rcon_password wil be automatically generated.
+// The given cvar layers are applied in order, see CVarLayer.
 func NewServerConfig( // see type ServerConfig
 	lifetime time.Duration,
 	valveAddonDirPath string,
 	maxPlayers int,
 	mapCycle []string,
-	cvars CVars,
+	layers []CVarLayer,
 ) (ServerConfig, error) {
 	var zero ServerConfig
 
@@ -167,10 +250,20 @@ func NewServerConfig( // see type ServerConfig
 	if lifetime < time.Minute || lifetime > (24*time.Hour) {
 		return zero, errors.New("server lifetime must be within ]1m;24h]")
 	}
+
+	for _, layer := range layers {
+		for k := range layer.CVars {
+			if IsGeneratedCVar(k) {
+				return zero, fmt.Errorf("cvar '%s' from layer '%s' cannot be overridden", k, layer.Name)
+			}
+		}
+	}
+
+	cvars := NewCVars()
 	cvars["mp_timeleft"] = strconv.Itoa(int(lifetime.Seconds()))
 	cvars["rcon_password"] = generatePassword(32)
 	cvars["sv_password"] = generatePassword(8)
-	cvars["hostname"] = fmt.Sprintf("HLDSBot %s playtest", mapCycle[0])
+	cvars["hostname"] = defaultHostname(mapCycle[0])
 
 	absValveAddonDirPath, err := resolveAddonDirPath(valveAddonDirPath)
 	if err != nil {
@@ -181,99 +274,217 @@ func NewServerConfig( // see type ServerConfig
 		valveAddonDirPath: absValveAddonDirPath,
 		maxPlayers:        maxPlayers,
 		mapCycle:          mapCycle,
+		layers:            layers,
 		cvars:             cvars,
 		lifetime:          lifetime,
 	}, nil
 }
 
+// Returns the effective value of a cvar once all layers are applied.
+func (cfg ServerConfig) CVar(key string) string {
+	if v, ok := cfg.cvars[key]; ok {
+		return v
+	}
+
+	for i := len(cfg.layers) - 1; i >= 0; i-- {
+		if v, ok := cfg.layers[i].CVars[key]; ok {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Writes the contents of instance.cfg, layers are written in order so the
+// last value set for a cvar is the one used by the server.
+func (cfg ServerConfig) WriteInstanceConfig(w io.Writer) error {
+	for _, layer := range cfg.layers {
+		if err := writeCVarLayer(w, layer.Name, layer.CVars); err != nil {
+			return err
+		}
+	}
+
+	if err := writeCVarLayer(w, "hldsbot", cfg.cvars); err != nil {
+		return err
+	}
+
+	// log is a command and can't be part of a layer, see ValidateCVar.
+	if cfg.logEcho {
+		if _, err := fmt.Fprint(w, "log on\nmp_logecho 1\n"); err != nil {
+			return fmt.Errorf("unable to enable logging: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Starts an HLTV proxy alongside the server, spectators won't take a player
+// slot.
+func (cfg *ServerConfig) EnableHLTV() {
+	cfg.hltv = true
+}
+
+func (cfg *ServerConfig) SetBranding(b Branding) error {
+	if err := b.validate(); err != nil {
+		return fmt.Errorf("invalid branding: %w", err)
+	}
+
+	if b.Hostname != "" {
+		cfg.cvars["hostname"] = b.Hostname
+	}
+	cfg.motd = b.MOTD
+
+	return nil
+}
+
+// Mounts and installs the given plugin bundles on the server, bundles
+// already added are ignored.
+func (cfg *ServerConfig) AddPluginBundles(bundles ...PluginBundle) {
+	for _, v := range bundles {
+		if slices.ContainsFunc(cfg.plugins, func(b PluginBundle) bool { return b.Name == v.Name }) {
+			continue
+		}
+
+		cfg.plugins = append(cfg.plugins, v)
+	}
+}
+
+// Records server-side demos of each map played using HLTV, they will be
+// published once the server is removed.
+func (cfg *ServerConfig) EnableDemoRecording() {
+	cfg.hltv = true
+	cfg.recordDemos = true
+}
+
+// Most slots the engine allows, used when the server a map will run on is
+// not known yet.
+const ServerPlayerSlots = 32
+
 func (cfg ServerConfig) ContainerConfig(port uint16) container.Config {
+	var cmd = []string{"-norestart"}
+	if !cfg.hltv {
+		cmd = append(cmd, "-nohltv")
+	}
+
 	return container.Config{
-		Cmd: []string{
-			"-norestart", "-nohltv",
+		Cmd: append(cmd,
 			"-port", strconv.Itoa(int(port)),
-			"-maxplayers", "32",
+			"-maxplayers", strconv.Itoa(cfg.maxPlayers),
 			"+map", cfg.mapCycle[0],
-		},
+		),
 		Image: HLDSDockerImage,
 	}
 }
 
-// Returns a list of temp files to remove once the server is to be deleted.
-func (cfg ServerConfig) HostConfig() (container.HostConfig, []string, error) {
-	mounts, tempFiles, err := cfg.writeConfigToDockerMounts()
-	if err != nil {
-		return container.HostConfig{}, nil, fmt.Errorf("unable to write server configuration: %w", err)
+// The proxy runs on the host network like the server it connects to.
+// Stdin is kept open to send console commands.
+func (cfg ServerConfig) HLTVContainerConfig(port, serverPort uint16) container.Config {
+	var cmd = []string{
+		"-port", strconv.Itoa(int(port)),
+		"+serverpassword", cfg.cvars["sv_password"],
+		"+spectatorpassword", cfg.cvars["sv_password"],
+	}
+
+	if cfg.recordDemos {
+		cmd = append(cmd, "+record", hltvDemoPrefix)
+	}
+
+	return container.Config{
+		Entrypoint: []string{hltvEntrypoint},
+		Cmd: append(cmd,
+			"+connect", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(serverPort))),
+		),
+		Image:     HLDSDockerImage,
+		OpenStdin: true,
 	}
+}
 
+func (cfg ServerConfig) HostConfig() container.HostConfig {
 	return container.HostConfig{
 		NetworkMode: "host",
 		AutoRemove:  true,
-		Mounts:      mounts,
-	}, tempFiles, nil
+		Mounts:      cfg.dockerMounts(),
+	}
 }
 
-func writeCVarsToTempfile(cvars CVars) (string, error) {
-	f, err := os.CreateTemp("", "cvars.*.cfg")
-	if err != nil {
-		return "", fmt.Errorf("unable to create temp file: %w", err)
+// Generated configuration files to write inside configDir before starting
+// the server.
+func (cfg ServerConfig) configFiles() (map[string][]byte, error) {
+	var instanceCfg, mapCycle bytes.Buffer
+
+	if err := cfg.WriteInstanceConfig(&instanceCfg); err != nil {
+		return nil, fmt.Errorf("unable to write instance configuration: %w", err)
+	}
+
+	for _, v := range cfg.mapCycle {
+		fmt.Fprintln(&mapCycle, v)
 	}
 
-	if err := cvars.Write(f); err != nil {
-		return "", fmt.Errorf("unable to write cvars to temp file: %w", err)
+	// TODO listip.cfg,banned.cfg
+	var ret = map[string][]byte{
+		"instance.cfg": instanceCfg.Bytes(),
+		"mapcycle.txt": mapCycle.Bytes(),
 	}
 
-	if err := f.Close(); err != nil {
-		return "", fmt.Errorf("unable to finish writing to temp file: %w", err)
+	if cfg.motd != "" {
+		ret["motd.txt"] = []byte(cfg.motd)
 	}
 
-	return f.Name(), nil
+	return ret, nil
 }
 
-func writeMapcycleToTempfile(mapCycle []string) (string, error) {
-	f, err := os.CreateTemp("", "mapcycle.*.txt")
+// Returns a tar archive of the generated configuration, to be extracted
+// inside configDir using the Docker copy API. This allows using a remote
+// Docker daemon and leaves nothing on the host.
+func (cfg ServerConfig) ConfigArchive() (io.Reader, error) {
+	files, err := cfg.configFiles()
 	if err != nil {
-		return "", fmt.Errorf("unable to create temp file: %w", err)
+		return nil, err
 	}
 
-	for _, v := range mapCycle {
-		fmt.Fprintln(f, v)
-	}
+	return tarFiles(files)
+}
 
-	if err := f.Close(); err != nil {
-		return "", fmt.Errorf("unable to finish writing to temp file: %w", err)
+// Files are owned by the container user.
+func tarFiles(files map[string][]byte) (io.Reader, error) {
+	names := make([]string, 0, len(files))
+	for k := range files {
+		names = append(names, k)
 	}
+	slices.Sort(names)
+
+	var (
+		buf bytes.Buffer
+		tw  = tar.NewWriter(&buf)
+		now = time.Now()
+	)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(files[name])),
+			Mode:     0o644,
+			Uid:      containerUID,
+			Gid:      containerUID,
+			ModTime:  now,
+		}); err != nil {
+			return nil, fmt.Errorf("unable to write tar header for '%s': %w", name, err)
+		}
 
-	return f.Name(), nil
-}
-
-func (cfg ServerConfig) writeConfigToDockerMounts() ([]mount.Mount, []string, error) {
-	// TODO listip.cfg,banned.cfg
-	var ret = make([]mount.Mount, 0, 2)
-	var tmpfiles = make([]string, 0, len(ret))
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, fmt.Errorf("unable to write '%s' to tar: %w", name, err)
+		}
+	}
 
-	instanceCfgSrc, err := writeCVarsToTempfile(cfg.cvars)
-	tmpfiles = append(tmpfiles, instanceCfgSrc)
-	if err != nil {
-		return nil, tmpfiles, fmt.Errorf("unable to write instance configuration: %w", err)
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to finish writing tar: %w", err)
 	}
-	ret = append(ret, mount.Mount{
-		Type:     mount.TypeBind,
-		Source:   instanceCfgSrc,
-		Target:   instanceCfgDest,
-		ReadOnly: true,
-	})
 
-	mapCycleSrc, err := writeMapcycleToTempfile(cfg.mapCycle)
-	if err != nil {
-		return nil, tmpfiles, fmt.Errorf("unable to write mapcycle: %w", err)
-	}
-	tmpfiles = append(tmpfiles, mapCycleSrc)
-	ret = append(ret, mount.Mount{
-		Type:     mount.TypeBind,
-		Source:   mapCycleSrc,
-		Target:   mapCycleDest,
-		ReadOnly: true,
-	})
+	return &buf, nil
+}
+
+func (cfg ServerConfig) dockerMounts() []mount.Mount {
+	var ret = make([]mount.Mount, 0, 1+len(cfg.plugins))
 
 	if cfg.valveAddonDirPath != "" {
 		ret = append(ret, mount.Mount{
@@ -284,7 +495,11 @@ func (cfg ServerConfig) writeConfigToDockerMounts() ([]mount.Mount, []string, er
 		})
 	}
 
-	return ret, tmpfiles, nil
+	for _, v := range cfg.plugins {
+		ret = append(ret, v.mount())
+	}
+
+	return ret
 }
 
 // Ensures we're not escaping our rudimentary chroot, relies on Abs calling Clean.
@@ -305,6 +520,52 @@ func resolveAddonDirPath(path string) (string, error) {
 	return abs, nil
 }
 
+func defaultHostname(mapName string) string {
+	return fmt.Sprintf("HLDSBot %s playtest", mapName)
+}
+
+// Map names end up in rcon commands, only allow what a sane BSP name would
+// contain.
+func isValidMapName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-+.!()[]", r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Moves the contents of srcDir inside dstDir, overwriting existing files.
+func mergeAddonDir(srcDir, dstDir string) error {
+	return filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("unable to get relative path: %w", err)
+		}
+		dst := filepath.Join(dstDir, rel)
+
+		if entry.IsDir() {
+			return os.MkdirAll(dst, 0o755)
+		}
+
+		log.Debug().Str("src", path).Str("dst", dst).Msg("moving file")
+		if err := os.Rename(path, dst); err != nil {
+			return fmt.Errorf("unable to move file: %w", err)
+		}
+
+		return nil
+	})
+}
+
 func generatePassword(size int) string {
 	if size < 2 {
 		panic(fmt.Errorf("requested password length is too short"))
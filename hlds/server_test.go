@@ -0,0 +1,68 @@
+package hlds_test
+
+import (
+	"archive/tar"
+	"hldsbot/hlds"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigArchive(t *testing.T) {
+	cfg, err := hlds.NewServerConfig(time.Hour, "", 2, []string{"crossfire", "stalkyard"}, []hlds.CVarLayer{
+		{Name: "preset", CVars: hlds.CVars{"mp_fraglimit": "50"}},
+	})
+	require.NoError(t, err)
+
+	files := readConfigArchive(t, cfg)
+	require.Equal(t, "crossfire\nstalkyard\n", files["mapcycle.txt"])
+	require.Contains(t, files["instance.cfg"], `"mp_fraglimit" "50"`)
+	require.Contains(t, files["instance.cfg"], `"sv_password" "`+cfg.CVar("sv_password")+`"`)
+	require.NotContains(t, files["instance.cfg"], "log on")
+}
+
+func TestContainerConfigMaxPlayers(t *testing.T) {
+	cfg, err := hlds.NewServerConfig(time.Hour, "", 12, []string{"crossfire"}, nil)
+	require.NoError(t, err)
+
+	cmd := strings.Join(cfg.ContainerConfig(27015).Cmd, " ")
+	require.Contains(t, cmd, "-maxplayers 12")
+}
+
+func TestConfigArchiveMatch(t *testing.T) {
+	cfg, err := hlds.NewServerConfig(time.Hour, "", 2, []string{"crossfire"}, nil)
+	require.NoError(t, err)
+	cfg.EnableMatch()
+
+	files := readConfigArchive(t, cfg)
+	require.Contains(t, files["instance.cfg"], "log on\nmp_logecho 1\n")
+}
+
+func readConfigArchive(t *testing.T, cfg hlds.ServerConfig) map[string]string {
+	t.Helper()
+
+	archive, err := cfg.ConfigArchive()
+	require.NoError(t, err)
+
+	var (
+		tr    = tar.NewReader(archive)
+		files = make(map[string]string)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, 1000, hdr.Uid, "files are owned by the container user")
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+
+	return files
+}
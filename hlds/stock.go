@@ -0,0 +1,199 @@
+package hlds
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var StockOverrideErr = errors.New("archive overrides stock Half-Life files")
+
+var MissingStockDataErr = errors.New("stock data was not generated, run `make stock-manifest`")
+
+// Returned by Extract under StockPolicyReject.
+type StockOverrideError struct {
+	Paths []string
+}
+
+func (err *StockOverrideError) Error() string {
+	return fmt.Sprintf("%s: %s", StockOverrideErr, strings.Join(err.Paths, ", "))
+}
+
+func (err *StockOverrideError) Unwrap() error {
+	return StockOverrideErr
+}
+
+// What to do with archive files that would replace a stock file with
+// different content. Identical copies are always skipped.
+type StockPolicy int
+
+const (
+	// Skip overriding files and extract the rest.
+	StockPolicyDrop StockPolicy = iota
+	// Refuse to extract the archive.
+	StockPolicyReject
+)
+
+var DefaultStockPolicy = StockPolicyDrop
+
+// Generated by `make stock-manifest`, sha256sum format.
+//
+//go:embed stock_manifest.txt
+var stockManifestData []byte
+
+var DefaultStockManifest = mustParseStockManifest(stockManifestData)
+
+// Archives can't be checked for stock files without the generated data,
+// returns MissingStockDataErr when it's missing.
+func CheckStockData() error {
+	if DefaultStockManifest.Len() == 0 {
+		return fmt.Errorf("%w: empty stock manifest", MissingStockDataErr)
+	}
+	if DefaultStockTextures.Len() == 0 {
+		return fmt.Errorf("%w: empty stock textures", MissingStockDataErr)
+	}
+
+	return nil
+}
+
+// SHA-256 of the stock files of valve/, by lowercased path relative to it.
+type StockManifest struct {
+	hashes map[string][sha256.Size]byte
+}
+
+// Reads sha256sum output, lines starting with # are ignored.
+func ParseStockManifest(r io.Reader) (*StockManifest, error) {
+	var (
+		ret     = StockManifest{hashes: make(map[string][sha256.Size]byte)}
+		scanner = bufio.NewScanner(r)
+		line    int
+	)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		sum, path, ok := strings.Cut(text, "  ")
+		if !ok {
+			return nil, fmt.Errorf("invalid stock manifest line %d", line)
+		}
+
+		var hash [sha256.Size]byte
+		if n, err := hex.Decode(hash[:], []byte(sum)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("invalid hash on stock manifest line %d", line)
+		}
+		ret.hashes[strings.ToLower(path)] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read stock manifest: %w", err)
+	}
+
+	return &ret, nil
+}
+
+func mustParseStockManifest(data []byte) *StockManifest {
+	ret, err := ParseStockManifest(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	return ret
+}
+
+func (manifest *StockManifest) Len() int {
+	return len(manifest.hashes)
+}
+
+func (manifest *StockManifest) Has(path string) bool {
+	_, ok := manifest.hashes[strings.ToLower(path)]
+	return ok
+}
+
+// Returns whether path is a stock file with sum as its hash.
+func (manifest *StockManifest) Matches(path string, sum []byte) bool {
+	hash, ok := manifest.hashes[strings.ToLower(path)]
+	return ok && bytes.Equal(hash[:], sum)
+}
+
+// Generated by `make stock-manifest` from the StockWADs, one "WAD TEXTURE"
+// pair per line.
+//
+//go:embed stock_textures.txt
+var stockTexturesData []byte
+
+var DefaultStockTextures = mustParseStockTextures(stockTexturesData)
+
+// Names of the textures of the stock WADs, lowercased.
+type StockTextures struct {
+	byWAD map[string]map[string]struct{}
+}
+
+// Reads "WAD TEXTURE" lines, lines starting with # are ignored.
+func ParseStockTextures(r io.Reader) (*StockTextures, error) {
+	var (
+		ret     = StockTextures{byWAD: make(map[string]map[string]struct{})}
+		scanner = bufio.NewScanner(r)
+		line    int
+	)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		wad, texture, ok := strings.Cut(strings.ToLower(text), " ")
+		if !ok || texture == "" {
+			return nil, fmt.Errorf("invalid stock textures line %d", line)
+		}
+
+		if ret.byWAD[wad] == nil {
+			ret.byWAD[wad] = make(map[string]struct{})
+		}
+		ret.byWAD[wad][texture] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read stock textures: %w", err)
+	}
+
+	return &ret, nil
+}
+
+func mustParseStockTextures(data []byte) *StockTextures {
+	ret, err := ParseStockTextures(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	return ret
+}
+
+func (textures *StockTextures) Len() int {
+	var ret int
+	for _, v := range textures.byWAD {
+		ret += len(v)
+	}
+
+	return ret
+}
+
+// Returns whether the textures of the stock WAD are listed.
+func (textures *StockTextures) Knows(wad string) bool {
+	_, ok := textures.byWAD[strings.ToLower(wad)]
+	return ok
+}
+
+func (textures *StockTextures) Has(wad, texture string) bool {
+	_, ok := textures.byWAD[strings.ToLower(wad)][strings.ToLower(texture)]
+	return ok
+}
@@ -0,0 +1,232 @@
+package hlds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Where extracted files are deduplicated, must be on the same filesystem as
+// the server addons dirs since they're hardlinked.
+const ContentStoreDir = UserContentDir + "/objects"
+
+var DefaultContentStore = NewContentStore(ContentStoreDir)
+
+// Content-addressed file store. Each unique file is stored once as
+// <dir>/<hash[:2]>/<hash> and server addons dirs are made of hardlinks to
+// these objects. The link count of an object is its reference count: an
+// object with a single link is not used by any server and can be collected.
+// Files in the store are read-only, a hardlinked file MUST NOT be written to
+// as it would change it for every server using it. Replacing (renaming over)
+// it is fine.
+type ContentStore struct {
+	dir string
+
+	// Prevents collecting an object that's about to be linked.
+	mutex sync.Mutex
+}
+
+func NewContentStore(dir string) *ContentStore {
+	return &ContentStore{dir: dir}
+}
+
+// Moves every regular file under dir to the store and replaces it with a
+// hardlink to its object, files already present in the store are replaced
+// by a link to the existing object.
+func (store *ContentStore) Import(dir string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var deduplicated, total int
+
+	if err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		reused, err := store.importFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to import '%s': %w", path, err)
+		}
+
+		total++
+		if reused {
+			deduplicated++
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("unable to import dir to content store: %w", err)
+	}
+
+	log.Debug().
+		Str("dir", dir).
+		Int("files", total).
+		Int("deduplicated", deduplicated).
+		Msg("Imported dir to content store.")
+
+	return nil
+}
+
+// Returns true if the object already existed.
+func (store *ContentStore) importFile(path string) (bool, error) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	objPath := store.objectPath(hash)
+	if _, err := os.Stat(objPath); err == nil {
+		// Link to a temp name first and rename over the file to never end
+		// up with a missing file if something fails.
+		tmp := path + ".link"
+		if err := os.Link(objPath, tmp); err != nil {
+			return false, fmt.Errorf("unable to link to object: %w", err)
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			return false, fmt.Errorf("unable to replace file with object link: %w", err)
+		}
+
+		return true, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("unable to stat object: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
+		return false, fmt.Errorf("unable to create object dir: %w", err)
+	}
+
+	if err := os.Chmod(path, 0o444); err != nil {
+		return false, fmt.Errorf("unable to make file read-only: %w", err)
+	}
+
+	if err := os.Link(path, objPath); err != nil {
+		return false, fmt.Errorf("unable to create object: %w", err)
+	}
+
+	return false, nil
+}
+
+func (store *ContentStore) objectPath(hash string) string {
+	return filepath.Join(store.dir, hash[:2], hash)
+}
+
+// Removes objects that are not linked from anywhere else.
+func (store *ContentStore) CollectGarbage() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var (
+		errs    []error
+		removed int
+		freed   int64
+	)
+
+	if err := filepath.WalkDir(store.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == store.dir {
+				return nil
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errors.New("unable to get file link count")
+		}
+
+		if stat.Nlink > 1 {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove object: %w", err))
+			return nil
+		}
+		removed++
+		freed += info.Size()
+
+		return nil
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("unable to walk content store: %w", err))
+	}
+
+	if removed > 0 {
+		log.Info().Int("objects", removed).Int64("bytes", freed).Msg("Collected unused content.")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns a name for the content of an imported dir: the same files at the
+// same paths have the same key whatever the dir. An object keeps its inode
+// for as long as it's linked, which saves hashing the files again.
+func (store *ContentStore) Key(dir string) (string, error) {
+	var h = sha256.New()
+	if err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errors.New("unable to get file inode")
+		}
+		fmt.Fprintf(h, "%s\x00%d:%d\n", filepath.ToSlash(rel), stat.Dev, stat.Ino)
+
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("unable to compute content key: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+package hlds_test
+
+import (
+	"hldsbot/hlds"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentStore(t *testing.T) {
+	var (
+		base    = t.TempDir()
+		objects = filepath.Join(base, "objects")
+		store   = hlds.NewContentStore(objects)
+		a       = filepath.Join(base, "a")
+		b       = filepath.Join(base, "b")
+	)
+
+	for _, dir := range []string{a, b} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "maps"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "maps/foo.bsp"), []byte("shared"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "maps/foo.res"), []byte(dir), 0o644))
+		require.NoError(t, store.Import(dir))
+	}
+
+	infoA, err := os.Stat(filepath.Join(a, "maps/foo.bsp"))
+	require.NoError(t, err)
+	infoB, err := os.Stat(filepath.Join(b, "maps/foo.bsp"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(infoA, infoB), "identical files are deduplicated")
+
+	content, err := os.ReadFile(filepath.Join(b, "maps/foo.res"))
+	require.NoError(t, err)
+	require.Equal(t, b, string(content), "distinct files are kept")
+
+	countObjects := func() int {
+		var n int
+		require.NoError(t, filepath.WalkDir(objects, func(_ string, d os.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				n++
+			}
+			return err
+		}))
+		return n
+	}
+	require.Equal(t, 3, countObjects())
+
+	require.NoError(t, os.RemoveAll(a))
+	require.NoError(t, store.CollectGarbage())
+	require.Equal(t, 2, countObjects(), "objects still used by b are kept")
+
+	require.NoError(t, os.RemoveAll(b))
+	require.NoError(t, store.CollectGarbage())
+	require.Equal(t, 0, countObjects(), "unused objects are collected")
+}
+
+func TestContentStoreKey(t *testing.T) {
+	var (
+		base  = t.TempDir()
+		store = hlds.NewContentStore(filepath.Join(base, "objects"))
+		dirs  = []string{filepath.Join(base, "a"), filepath.Join(base, "b"), filepath.Join(base, "c")}
+		keys  []string
+	)
+
+	for i, dir := range dirs {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "maps"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "maps/foo.bsp"), []byte("shared"), 0o644))
+		if i == 2 {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "maps/foo.res"), []byte("other"), 0o644))
+		}
+		require.NoError(t, store.Import(dir))
+
+		key, err := store.Key(dir)
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+
+	require.Equal(t, keys[0], keys[1], "same content, same key")
+	require.NotEqual(t, keys[0], keys[2])
+
+	published := filepath.Join(base, "published")
+	require.NoError(t, hlds.LinkTree(dirs[0], published))
+	key, err := store.Key(published)
+	require.NoError(t, err)
+	require.Equal(t, keys[0], key, "linked copies have the same key")
+}
@@ -0,0 +1,126 @@
+//go:build ignore
+
+// Writes the RAR fixtures used by the map archive tests. There's no free
+// software able to create RAR archives so we write "stored" (uncompressed)
+// archives by hand, compressed.rar covers decompression.
+// Usage: go run gen_rar.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+)
+
+var files = []struct {
+	name    string
+	content string
+}{
+	{"mymap/maps/rartest.bsp", "not really a BSP"},
+	{"mymap/gfx/env/rartestbk.tga", "not really a TGA"},
+	{"mymap/readme.txt", "hello"},
+	{`mymap\sound\rartest.wav`, "not really a WAV"},
+	{"../rartest.txt", "escaping the archive"},
+}
+
+func main() {
+	if err := os.WriteFile("map.rar4.rar", rar4(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile("map.rar5.rar", rar5(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func rar4() []byte {
+	var out bytes.Buffer
+	out.Write([]byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x00})
+
+	// Headers are CRC16 (low bits of CRC32 of everything after the CRC),
+	// type, flags, size, then type-specific fields.
+	header := func(typ byte, flags uint16, fields []byte) {
+		var h bytes.Buffer
+		h.WriteByte(typ)
+		_ = binary.Write(&h, binary.LittleEndian, flags)
+		_ = binary.Write(&h, binary.LittleEndian, uint16(2+h.Len()+2+len(fields)))
+		h.Write(fields)
+
+		_ = binary.Write(&out, binary.LittleEndian, uint16(crc32.ChecksumIEEE(h.Bytes())))
+		out.Write(h.Bytes())
+	}
+
+	header(0x73, 0, make([]byte, 6)) // main header
+
+	for _, f := range files {
+		var fields bytes.Buffer
+		_ = binary.Write(&fields, binary.LittleEndian, uint32(len(f.content))) // packed
+		_ = binary.Write(&fields, binary.LittleEndian, uint32(len(f.content))) // unpacked
+		fields.WriteByte(3)                                                    // unix
+		_ = binary.Write(&fields, binary.LittleEndian, crc32.ChecksumIEEE([]byte(f.content)))
+		_ = binary.Write(&fields, binary.LittleEndian, uint32(0x58210000)) // DOS time, 2024-01-01
+		fields.WriteByte(29)                                               // version needed
+		fields.WriteByte(0x30)                                             // store
+		_ = binary.Write(&fields, binary.LittleEndian, uint16(len(f.name)))
+		_ = binary.Write(&fields, binary.LittleEndian, uint32(0o100644))
+		fields.WriteString(f.name)
+
+		header(0x74, 0x8000, fields.Bytes())
+		out.WriteString(f.content)
+	}
+
+	header(0x7b, 0x4000, nil) // end of archive
+
+	return out.Bytes()
+}
+
+func rar5() []byte {
+	var out bytes.Buffer
+	out.Write([]byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x01, 0x00})
+
+	vint := func(buf *bytes.Buffer, v uint64) {
+		buf.Write(binary.AppendUvarint(nil, v))
+	}
+
+	// Headers are CRC32 of everything after it, header size then the
+	// header itself.
+	header := func(typ, flags uint64, dataSize int, fields []byte) {
+		var h bytes.Buffer
+		vint(&h, typ)
+		vint(&h, flags)
+		if flags&0x0002 != 0 {
+			vint(&h, uint64(dataSize))
+		}
+		h.Write(fields)
+
+		var sized bytes.Buffer
+		vint(&sized, uint64(h.Len()))
+		sized.Write(h.Bytes())
+
+		_ = binary.Write(&out, binary.LittleEndian, crc32.ChecksumIEEE(sized.Bytes()))
+		out.Write(sized.Bytes())
+	}
+
+	header(1, 0, 0, []byte{0}) // main header, no archive flags
+
+	for _, f := range files {
+		var fields bytes.Buffer
+		vint(&fields, 0x0004) // CRC32 present
+		vint(&fields, uint64(len(f.content)))
+		vint(&fields, 0o100644)
+		_ = binary.Write(&fields, binary.LittleEndian, crc32.ChecksumIEEE([]byte(f.content)))
+		vint(&fields, 0) // store
+		vint(&fields, 1) // unix
+		vint(&fields, uint64(len(f.name)))
+		fields.WriteString(f.name)
+
+		header(2, 0x0002, len(f.content), fields.Bytes())
+		out.WriteString(f.content)
+	}
+
+	header(5, 0, 0, []byte{0}) // end of archive
+
+	return out.Bytes()
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	_ "embed"
 	"hldsbot/bot"
 	"hldsbot/hlds"
+	"hldsbot/settings"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -15,6 +19,12 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// The server.cfg baked in the HLDS image, its cvars are the first layer of
+// every server configuration.
+//
+//go:embed docker/server.cfg
+var imageServerCfg []byte
+
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{
 		Out:        os.Stderr,
@@ -33,7 +43,7 @@ func main() {
 	}()
 
 	pool, err := hlds.NewPool(
-		dockerClient, 2, 27015,
+		dockerClient, 2, 27015, 27020,
 		os.Getenv("HLDSBOT_BASE_DOWNLOAD_URL"),
 	)
 
@@ -41,10 +51,28 @@ func main() {
 		log.Fatal().Err(err).Msg("unable to init hlds.Pool")
 	}
 
+	if os.Getenv("HLDSBOT_REJECT_STOCK_OVERRIDES") != "" {
+		hlds.DefaultStockPolicy = hlds.StockPolicyReject
+	}
+	if err := hlds.CheckStockData(); err != nil {
+		log.Fatal().Err(err).Msg("unable to protect stock files")
+	}
+
+	imageDefaults, err := hlds.ParseCVars(bytes.NewReader(imageServerCfg))
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to parse image server.cfg")
+	}
+
+	settings, err := settings.Load(getDataDir(), imageDefaults)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to load settings")
+	}
+
 	bot, err := bot.New(
 		os.Getenv("HLDSBOT_DISCORD_TOKEN"),
 		os.Getenv("HLDSBOT_STEAM_REDIRECT_URL"),
 		pool,
+		settings,
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to init discord bot")
@@ -54,6 +82,19 @@ func main() {
 	log.Info().Msg("HLDSBot shutdown complete. ")
 }
 
+func getDataDir() string {
+	if dir := os.Getenv("HLDSBOT_DATA_DIR"); dir != "" {
+		return dir
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to find a data dir, set HLDSBOT_DATA_DIR")
+	}
+
+	return filepath.Join(dir, "hldsbot")
+}
+
 // Run all background processes and cleanup everything as soon as one of them
 // closes or we're signaled to exit.
 func dispatch(ctx context.Context, pool *hlds.Pool, bot *bot.Bot) {
@@ -0,0 +1,86 @@
+package overview
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+type bmpHeader struct {
+	// BITMAPFILEHEADER
+	Magic      [2]byte
+	FileSize   uint32
+	_          uint32
+	DataOffset uint32
+
+	// BITMAPINFOHEADER
+	HeaderSize      uint32
+	Width           int32
+	Height          int32 // positive, rows are stored bottom-up
+	Planes          uint16
+	BitsPerPixel    uint16
+	Compression     uint32
+	ImageSize       uint32
+	XPixelsPerMeter int32
+	YPixelsPerMeter int32
+	ColorsUsed      uint32
+	ColorsImportant uint32
+}
+
+// Writes an uncompressed 8-bit BMP, the only kind the engine loads as
+// overview.
+func EncodeBMP(w io.Writer, img *image.Paletted) error {
+	if len(img.Palette) > 256 {
+		return fmt.Errorf("palette has %d colors, BMP allows 256", len(img.Palette))
+	}
+
+	var (
+		bounds   = img.Bounds()
+		stride   = (bounds.Dx() + 3) &^ 3
+		dataSize = stride * bounds.Dy()
+		offset   = binary.Size(bmpHeader{}) + 256*4
+		header   = bmpHeader{
+			Magic:        [2]byte{'B', 'M'},
+			FileSize:     uint32(offset + dataSize),
+			DataOffset:   uint32(offset),
+			HeaderSize:   40,
+			Width:        int32(bounds.Dx()),
+			Height:       int32(bounds.Dy()),
+			Planes:       1,
+			BitsPerPixel: 8,
+			ImageSize:    uint32(dataSize),
+			ColorsUsed:   256,
+		}
+		bw = bufio.NewWriter(w)
+	)
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("unable to write BMP header: %w", err)
+	}
+
+	var palette [256 * 4]byte // BGRX
+	for i, c := range img.Palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		palette[4*i], palette[4*i+1], palette[4*i+2] = rgba.B, rgba.G, rgba.R
+	}
+	if _, err := bw.Write(palette[:]); err != nil {
+		return fmt.Errorf("unable to write BMP palette: %w", err)
+	}
+
+	row := make([]byte, stride)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		start := img.PixOffset(bounds.Min.X, y)
+		copy(row, img.Pix[start:start+bounds.Dx()])
+		if _, err := bw.Write(row); err != nil {
+			return fmt.Errorf("unable to write BMP pixels: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("unable to write BMP: %w", err)
+	}
+
+	return nil
+}
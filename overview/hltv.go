@@ -0,0 +1,79 @@
+package overview
+
+import (
+	"bytes"
+	"fmt"
+	"hldsbot/bsp"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Spectator overviews are 4:3 images the client tiles by 128 pixels, each
+// pixel covers 8/ZOOM world units.
+const (
+	hltvWidth  = 1024
+	hltvHeight = 768
+	hltvMargin = 16
+)
+
+const hltvDescriptionTPL = `// overview description file for %[1]s.bsp, generated by HLDSBot
+
+global
+{
+	ZOOM	%.2[2]f
+	ORIGIN	%.2[3]f	%.2[4]f	%.2[5]f
+	ROTATED	1
+}
+
+layer
+{
+	IMAGE	"overviews/%[1]s.bmp"
+	HEIGHT	%.2[5]f
+}
+`
+
+// Writes overviews/<mapName>.bmp and its description file under baseDir,
+// returns their paths relative to baseDir.
+func WriteHLTV(baseDir, mapName string, b *bsp.BSP) ([]string, error) {
+	scene, err := newScene(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// ZOOM is written with two decimals, the image must match it exactly.
+	var (
+		view = scene.fitView(hltvWidth, hltvHeight, hltvMargin)
+		zoom = math.Max(math.Floor(800/view.Scale)/100, 0.01)
+	)
+	view.Scale = 8 / zoom
+
+	var bmp bytes.Buffer
+	if err := EncodeBMP(&bmp, scene.render(view)); err != nil {
+		return nil, err
+	}
+
+	var (
+		description = fmt.Sprintf(hltvDescriptionTPL, mapName, zoom, view.Center[0], view.Center[1], scene.mins[2])
+		files       = []struct {
+			name string
+			data []byte
+		}{
+			{path.Join("overviews", mapName+".bmp"), bmp.Bytes()},
+			{path.Join("overviews", mapName+".txt"), []byte(description)},
+		}
+		ret = make([]string, 0, len(files))
+	)
+	if err := os.MkdirAll(filepath.Join(baseDir, "overviews"), 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create overviews dir: %w", err)
+	}
+	for _, v := range files {
+		if err := os.WriteFile(filepath.Join(baseDir, v.name), v.data, 0o644); err != nil {
+			return ret, fmt.Errorf("unable to write overview: %w", err)
+		}
+		ret = append(ret, v.name)
+	}
+
+	return ret, nil
+}
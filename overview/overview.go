@@ -0,0 +1,337 @@
+// Package overview renders orthographic top-down images of GoldSrc maps
+// from their BSP, on the CPU.
+package overview
+
+import (
+	"errors"
+	"hldsbot/bsp"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+)
+
+var NoFloorErr = errors.New("map has no floor to render")
+
+// Palette indices.
+const (
+	colorBackground = 0
+	colorFloorFirst = 1   // lowest floors
+	colorFloorLast  = 191 // highest floors
+	colorWaterFirst = 192
+	colorWaterLast  = 223
+	colorOutline    = 252
+	colorSpawn      = 253
+	colorWeapon     = 254
+)
+
+var Palette = buildPalette()
+
+func buildPalette() color.Palette {
+	var ret = make(color.Palette, 256)
+	for i := range ret {
+		ret[i] = color.RGBA{A: 0xff}
+	}
+
+	gradient := func(first, last int, from, to color.RGBA) {
+		for i := first; i <= last; i++ {
+			t := float64(i-first) / float64(last-first)
+			lerp := func(a, b uint8) uint8 {
+				return uint8(float64(a) + t*(float64(b)-float64(a)))
+			}
+			ret[i] = color.RGBA{lerp(from.R, to.R), lerp(from.G, to.G), lerp(from.B, to.B), 0xff}
+		}
+	}
+	gradient(colorFloorFirst, colorFloorLast, color.RGBA{40, 46, 60, 0xff}, color.RGBA{232, 226, 204, 0xff})
+	gradient(colorWaterFirst, colorWaterLast, color.RGBA{16, 40, 96, 0xff}, color.RGBA{80, 144, 224, 0xff})
+	ret[colorOutline] = color.RGBA{0, 0, 0, 0xff}
+	ret[colorSpawn] = color.RGBA{64, 224, 64, 0xff}
+	ret[colorWeapon] = color.RGBA{255, 144, 0, 0xff}
+
+	return ret
+}
+
+// Orthographic projection of the world XY plane, world +Y is up.
+type View struct {
+	Width, Height int
+	Center        [2]float64 // world coordinates of the image center
+	Scale         float64    // world units per pixel
+}
+
+func (view View) project(v bsp.Vec3) (float64, float64) {
+	return float64(view.Width)/2 + (float64(v[0])-view.Center[0])/view.Scale,
+		float64(view.Height)/2 - (float64(v[1])-view.Center[1])/view.Scale
+}
+
+// Faces facing up, what is seen from above.
+type floor struct {
+	vertices []bsp.Vec3
+	water    bool
+}
+
+type scene struct {
+	floors     []floor
+	mins, maxs bsp.Vec3
+}
+
+// Tools textures that are not drawn in game.
+var hiddenTextures = []string{"aaatrigger", "bevel", "clip", "hint", "null", "origin", "skip", "sky"}
+
+// Steeper faces are walls.
+const minFloorNormalZ = 0.3
+
+// Collects the floors of the world and of visible brush entities.
+func newScene(b *bsp.BSP) (scene, error) {
+	type model struct {
+		index  int
+		offset bsp.Vec3
+	}
+	var models = []model{{index: 0}}
+	for _, ent := range b.Entities {
+		i, ok := ent.BrushModel()
+		if !ok || i == 0 || strings.HasPrefix(ent.ClassName(), "trigger_") {
+			continue
+		}
+
+		// Rotating entities are built around their origin.
+		origin, _ := ent.Origin()
+		models = append(models, model{index: i, offset: origin})
+	}
+
+	var ret scene
+	for _, m := range models {
+		if m.index >= len(b.Models) {
+			continue
+		}
+
+		first, count := int64(b.Models[m.index].FirstFace), int64(b.Models[m.index].NumFaces)
+		if first < 0 || count < 0 || first+count > int64(len(b.Faces)) {
+			continue
+		}
+
+		for _, face := range b.Faces[first : first+count] {
+			normal, err := b.FaceNormal(face)
+			if err != nil || normal[2] < minFloorNormalZ {
+				continue
+			}
+
+			tex, ok := b.FaceTexture(face)
+			name := strings.ToLower(tex.Name)
+			if !ok || slices.Contains(hiddenTextures, name) {
+				continue
+			}
+
+			vertices, err := b.FaceVertices(face)
+			if err != nil || len(vertices) < 3 {
+				continue
+			}
+
+			for i := range vertices {
+				for axis := range vertices[i] {
+					vertices[i][axis] += m.offset[axis]
+					if len(ret.floors) == 0 && i == 0 {
+						ret.mins[axis], ret.maxs[axis] = vertices[i][axis], vertices[i][axis]
+					}
+					ret.mins[axis] = min(ret.mins[axis], vertices[i][axis])
+					ret.maxs[axis] = max(ret.maxs[axis], vertices[i][axis])
+				}
+			}
+
+			ret.floors = append(ret.floors, floor{
+				vertices: vertices,
+				water:    strings.HasPrefix(name, "!") || strings.HasPrefix(name, "water"),
+			})
+		}
+	}
+
+	if len(ret.floors) == 0 {
+		return ret, NoFloorErr
+	}
+
+	return ret, nil
+}
+
+// Returns a view of the floors fitting in width×height pixels with margin
+// pixels on each side.
+func FitView(b *bsp.BSP, width, height, margin int) (View, error) {
+	scene, err := newScene(b)
+	if err != nil {
+		return View{}, err
+	}
+
+	return scene.fitView(width, height, margin), nil
+}
+
+func (scene scene) fitView(width, height, margin int) View {
+	var (
+		mins, maxs = scene.mins, scene.maxs
+		sizeX      = math.Max(float64(maxs[0]-mins[0]), 1)
+		sizeY      = math.Max(float64(maxs[1]-mins[1]), 1)
+		scale      = math.Max(
+			sizeX/float64(max(width-2*margin, 1)),
+			sizeY/float64(max(height-2*margin, 1)),
+		)
+	)
+
+	return View{
+		Width:  width,
+		Height: height,
+		Center: [2]float64{float64(mins[0]+maxs[0]) / 2, float64(mins[1]+maxs[1]) / 2},
+		Scale:  scale,
+	}
+}
+
+// Renders the floors shaded by height, the highest floor wins where floors
+// overlap. Spawn points and weapons are drawn on top when markers is set.
+func Render(b *bsp.BSP, view View, markers bool) (*image.Paletted, error) {
+	scene, err := newScene(b)
+	if err != nil {
+		return nil, err
+	}
+
+	img := scene.render(view)
+	if markers {
+		drawMarkers(img, b, view)
+	}
+
+	return img, nil
+}
+
+func (scene scene) render(view View) *image.Paletted {
+	var (
+		img    = image.NewPaletted(image.Rect(0, 0, view.Width, view.Height), Palette)
+		depth  = make([]float32, view.Width*view.Height)
+		mins   = scene.mins
+		height = math.Max(float64(scene.maxs[2]-mins[2]), 1)
+	)
+	for i := range depth {
+		depth[i] = float32(math.Inf(-1))
+	}
+
+	for _, face := range scene.floors {
+		first, last := colorFloorFirst, colorFloorLast
+		if face.water {
+			first, last = colorWaterFirst, colorWaterLast
+		}
+
+		shade := func(z float64) uint8 {
+			if scene.maxs[2] == mins[2] {
+				return uint8(last) // flat maps
+			}
+			t := math.Min(math.Max((z-float64(mins[2]))/height, 0), 1)
+			return uint8(first + int(math.Round(t*float64(last-first))))
+		}
+
+		// Faces are convex, draw them as fans.
+		for i := 1; i+1 < len(face.vertices); i++ {
+			fillTriangle(img, depth, view, shade, face.vertices[0], face.vertices[i], face.vertices[i+1])
+		}
+	}
+
+	return img
+}
+
+// Fills the pixels whose center is inside the triangle and higher than what
+// was drawn there before.
+func fillTriangle(img *image.Paletted, depth []float32, view View, shade func(float64) uint8, a, b, c bsp.Vec3) {
+	var (
+		ax, ay = view.project(a)
+		bx, by = view.project(b)
+		cx, cy = view.project(c)
+		area   = (bx-ax)*(cy-ay) - (cx-ax)*(by-ay)
+	)
+	if math.Abs(area) < 1e-9 {
+		return
+	}
+
+	var (
+		minX = max(int(math.Floor(math.Min(ax, math.Min(bx, cx)))), 0)
+		maxX = min(int(math.Ceil(math.Max(ax, math.Max(bx, cx)))), view.Width-1)
+		minY = max(int(math.Floor(math.Min(ay, math.Min(by, cy)))), 0)
+		maxY = min(int(math.Ceil(math.Max(ay, math.Max(by, cy)))), view.Height-1)
+	)
+	for y := minY; y <= maxY; y++ {
+		py := float64(y) + 0.5
+		for x := minX; x <= maxX; x++ {
+			px := float64(x) + 0.5
+			wa := ((bx-px)*(cy-py) - (cx-px)*(by-py)) / area
+			wb := ((cx-px)*(ay-py) - (ax-px)*(cy-py)) / area
+			wc := 1 - wa - wb
+			if wa < 0 || wb < 0 || wc < 0 {
+				continue
+			}
+
+			z := wa*float64(a[2]) + wb*float64(b[2]) + wc*float64(c[2])
+			if i := y*view.Width + x; float32(z) > depth[i] {
+				depth[i] = float32(z)
+				img.SetColorIndex(x, y, shade(z))
+			}
+		}
+	}
+}
+
+func drawMarkers(img *image.Paletted, b *bsp.BSP, view View) {
+	radius := max(3, min(view.Width, view.Height)/128)
+	for _, ent := range b.Entities {
+		var (
+			className = strings.ToLower(ent.ClassName())
+			index     uint8
+		)
+		switch {
+		case className == "info_player_deathmatch":
+			index = colorSpawn
+		case strings.HasPrefix(className, "weapon_"):
+			index = colorWeapon
+		default:
+			continue
+		}
+
+		origin, ok := ent.Origin()
+		if !ok {
+			continue
+		}
+
+		x, y := view.project(origin)
+		drawDisc(img, int(x), int(y), radius+1, colorOutline)
+		drawDisc(img, int(x), int(y), radius, index)
+	}
+}
+
+func drawDisc(img *image.Paletted, cx, cy, radius int, index uint8) {
+	for y := cy - radius; y <= cy+radius; y++ {
+		for x := cx - radius; x <= cx+radius; x++ {
+			dx, dy := x-cx, y-cy
+			if dx*dx+dy*dy <= radius*radius && image.Pt(x, y).In(img.Rect) {
+				img.SetColorIndex(x, y, index)
+			}
+		}
+	}
+}
+
+// Renders the whole map with its spawn points and weapons in at most
+// size×size pixels, keeping its aspect ratio.
+func Preview(b *bsp.BSP, size int) (*image.Paletted, error) {
+	scene, err := newScene(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		margin       = size / 32
+		sizeX, sizeY = float64(scene.maxs[0] - scene.mins[0]), float64(scene.maxs[1] - scene.mins[1])
+		width        = size
+		height       = size
+	)
+	if sizeX > sizeY {
+		height = max(int(float64(size-2*margin)*sizeY/sizeX)+2*margin, 2*margin+1)
+	} else if sizeY > 0 {
+		width = max(int(float64(size-2*margin)*sizeX/sizeY)+2*margin, 2*margin+1)
+	}
+
+	view := scene.fitView(width, height, margin)
+	img := scene.render(view)
+	drawMarkers(img, b, view)
+
+	return img, nil
+}
@@ -0,0 +1,109 @@
+package overview_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hldsbot/bsp"
+	"hldsbot/overview"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Builds a BSP with a single 512×512 floor centered on the origin.
+func buildFloorBSP(t *testing.T, entities string) *bsp.BSP {
+	t.Helper()
+
+	le := func(values ...any) []byte {
+		var buf bytes.Buffer
+		for _, v := range values {
+			require.NoError(t, binary.Write(&buf, binary.LittleEndian, v))
+		}
+		return buf.Bytes()
+	}
+
+	lumps := map[int][]byte{
+		bsp.LumpEntities:  []byte(entities),
+		bsp.LumpPlanes:    le(bsp.Plane{Normal: bsp.Vec3{0, 0, 1}, Type: 2}),
+		bsp.LumpTextures:  le(uint32(1), int32(8), [16]byte{'f', 'l', 'o', 'o', 'r'}, uint32(64), uint32(64), [4]uint32{}),
+		bsp.LumpVertices:  le([]bsp.Vec3{{-256, -256, 0}, {256, -256, 0}, {256, 256, 0}, {-256, 256, 0}}),
+		bsp.LumpTexInfo:   le(bsp.TexInfo{}),
+		bsp.LumpFaces:     le(bsp.Face{NumEdges: 4}),
+		bsp.LumpEdges:     le([]bsp.Edge{{0, 1}, {1, 2}, {2, 3}, {0, 3}}),
+		bsp.LumpSurfEdges: le([]int32{0, 1, 2, -3}),
+		bsp.LumpModels:    le(bsp.Model{NumFaces: 1}),
+	}
+
+	var (
+		headerSize = 4 + 15*8
+		header     = bytes.NewBuffer(le(int32(bsp.Version)))
+		data       bytes.Buffer
+	)
+	for i := 0; i < 15; i++ {
+		header.Write(le([2]int32{int32(headerSize + data.Len()), int32(len(lumps[i]))}))
+		data.Write(lumps[i])
+	}
+
+	b, err := bsp.Parse(append(header.Bytes(), data.Bytes()...))
+	require.NoError(t, err)
+
+	return b
+}
+
+const testEntities = `{"classname" "worldspawn"}
+{"classname" "info_player_deathmatch" "origin" "128 128 0"}
+{"classname" "weapon_crossbow" "origin" "-128 -128 0"}`
+
+func TestPreview(t *testing.T) {
+	b := buildFloorBSP(t, testEntities)
+
+	img, err := overview.Preview(b, 512)
+	require.NoError(t, err)
+	require.Equal(t, 512, img.Bounds().Dx())
+	require.Equal(t, 512, img.Bounds().Dy())
+
+	var (
+		corner = img.ColorIndexAt(0, 0)
+		center = img.ColorIndexAt(256, 256)
+		spawn  = img.ColorIndexAt(256+128*480/512, 256-128*480/512)
+		weapon = img.ColorIndexAt(256-128*480/512, 256+128*480/512)
+	)
+	require.NotEqual(t, corner, center, "floor is drawn over the background")
+	require.Equal(t, center, img.ColorIndexAt(64, 64), "faces are drawn whole")
+	require.NotEqual(t, center, spawn, "spawn is marked")
+	require.NotEqual(t, center, weapon, "weapon is marked")
+	require.NotEqual(t, spawn, weapon)
+
+	_, err = overview.Preview(buildFloorBSP(t, testEntities[:26]+`{"classname" "func_wall" "model" "*1"}`), 512)
+	require.NoError(t, err, "brush models out of bounds are ignored")
+
+	var empty bsp.BSP
+	_, err = overview.Preview(&empty, 512)
+	require.ErrorIs(t, err, overview.NoFloorErr)
+}
+
+func TestWriteHLTV(t *testing.T) {
+	var (
+		b   = buildFloorBSP(t, testEntities)
+		dir = t.TempDir()
+	)
+
+	files, err := overview.WriteHLTV(dir, "foo", b)
+	require.NoError(t, err)
+	require.Equal(t, []string{"overviews/foo.bmp", "overviews/foo.txt"}, files)
+
+	bmp, err := os.ReadFile(filepath.Join(dir, "overviews/foo.bmp"))
+	require.NoError(t, err)
+	require.Equal(t, "BM", string(bmp[:2]))
+	require.Len(t, bmp, 54+256*4+1024*768)
+	require.Equal(t, uint32(len(bmp)), binary.LittleEndian.Uint32(bmp[2:]))
+	require.Equal(t, uint16(8), binary.LittleEndian.Uint16(bmp[28:]), "8-bit")
+
+	txt, err := os.ReadFile(filepath.Join(dir, "overviews/foo.txt"))
+	require.NoError(t, err)
+	require.Contains(t, string(txt), "ZOOM\t11.50\n")
+	require.Contains(t, string(txt), "ORIGIN\t0.00\t0.00\t0.00\n")
+	require.Contains(t, string(txt), `IMAGE	"overviews/foo.bmp"`)
+}
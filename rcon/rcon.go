@@ -0,0 +1,163 @@
+// Package rcon implements the GoldSrc remote console protocol.
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidCommandErr = errors.New("rcon command contains forbidden characters")
+	BadPasswordErr    = errors.New("rcon password rejected by server")
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+
+	// After the first response packet, wait this long for the rest of a
+	// multi-packet response before considering the output complete.
+	trailingPacketsTimeout = 100 * time.Millisecond
+
+	maxPacketSize = 4096
+)
+
+var (
+	header      = []byte{0xff, 0xff, 0xff, 0xff}
+	splitHeader = []byte{0xfe, 0xff, 0xff, 0xff}
+)
+
+type Client struct {
+	addr     string
+	password string
+	timeout  time.Duration
+}
+
+func NewClient(addr, password string) *Client {
+	return &Client{
+		addr:     addr,
+		password: password,
+		timeout:  defaultTimeout,
+	}
+}
+
+// Executes a single command on the remote server and returns its console
+// output. Commands are sent as-is, chaining multiple commands using ';' is
+// possible but newlines are not.
+func (client *Client) Exec(ctx context.Context, command string) (string, error) {
+	if strings.ContainsAny(command, "\r\n\x00") {
+		return "", InvalidCommandErr
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", client.addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to dial rcon server: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(client.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", fmt.Errorf("unable to set deadline: %w", err)
+	}
+
+	challenge, err := getChallenge(conn)
+	if err != nil {
+		return "", fmt.Errorf("unable to obtain rcon challenge: %w", err)
+	}
+
+	if err := send(conn, fmt.Sprintf(`rcon %s "%s" %s`, challenge, client.password, command)); err != nil {
+		return "", fmt.Errorf("unable to send rcon command: %w", err)
+	}
+
+	output, err := readOutput(conn)
+	if err != nil {
+		return "", fmt.Errorf("unable to read rcon response: %w", err)
+	}
+
+	if strings.HasPrefix(output, "Bad rcon_password") {
+		return "", BadPasswordErr
+	}
+
+	return output, nil
+}
+
+func send(conn net.Conn, payload string) error {
+	var buf = make([]byte, 0, len(header)+len(payload)+1)
+	buf = append(buf, header...)
+	buf = append(buf, payload...)
+	buf = append(buf, '\n')
+
+	_, err := conn.Write(buf)
+	return err
+}
+
+func getChallenge(conn net.Conn) (string, error) {
+	if err := send(conn, "challenge rcon"); err != nil {
+		return "", err
+	}
+
+	var buf = make([]byte, maxPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	payload, ok := bytes.CutPrefix(buf[:n], header)
+	if !ok {
+		return "", errors.New("invalid challenge response header")
+	}
+
+	fields := strings.Fields(string(bytes.TrimRight(payload, "\x00\n")))
+	if len(fields) != 3 || fields[0] != "challenge" || fields[1] != "rcon" {
+		return "", fmt.Errorf("unexpected challenge response: %q", payload)
+	}
+
+	return fields[2], nil
+}
+
+// Responses are prefixed with 'l' and can span multiple packets. Split
+// packets are not expected to be sent for rcon output, they're ignored.
+func readOutput(conn net.Conn) (string, error) {
+	var (
+		buf = make([]byte, maxPacketSize)
+		out strings.Builder
+	)
+
+	for first := true; ; first = false {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if !first && errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return "", err
+		}
+
+		if bytes.HasPrefix(buf[:n], splitHeader) {
+			continue
+		}
+
+		payload, ok := bytes.CutPrefix(buf[:n], header)
+		if !ok {
+			return "", errors.New("invalid response header")
+		}
+		payload = bytes.TrimPrefix(payload, []byte{'l'})
+		out.Write(bytes.TrimRight(payload, "\x00"))
+
+		if first {
+			if err := conn.SetReadDeadline(time.Now().Add(trailingPacketsTimeout)); err != nil {
+				return "", fmt.Errorf("unable to set deadline: %w", err)
+			}
+		}
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,61 @@
+package rcon_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hldsbot/rcon"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal HLDS rcon implementation answering with the received command.
+func fakeServer(t *testing.T, password string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		var buf = make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			payload := strings.TrimSpace(string(bytes.TrimPrefix(buf[:n], []byte{0xff, 0xff, 0xff, 0xff})))
+			var res string
+			switch {
+			case payload == "challenge rcon":
+				res = "challenge rcon 1234\n"
+			case strings.HasPrefix(payload, fmt.Sprintf(`rcon 1234 "%s" `, password)):
+				res = "l" + strings.TrimPrefix(payload, fmt.Sprintf(`rcon 1234 "%s" `, password)) + "\n"
+			default:
+				res = "lBad rcon_password.\n"
+			}
+
+			_, _ = conn.WriteTo(append([]byte{0xff, 0xff, 0xff, 0xff}, res...), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestExec(t *testing.T) {
+	addr := fakeServer(t, "hunter2")
+
+	out, err := rcon.NewClient(addr, "hunter2").Exec(context.Background(), "status")
+	require.NoError(t, err)
+	require.Equal(t, "status\n", out)
+
+	_, err = rcon.NewClient(addr, "wrong").Exec(context.Background(), "status")
+	require.ErrorIs(t, err, rcon.BadPasswordErr)
+
+	_, err = rcon.NewClient(addr, "hunter2").Exec(context.Background(), "status\nquit")
+	require.ErrorIs(t, err, rcon.InvalidCommandErr)
+}
@@ -0,0 +1,137 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hldsbot/hlds"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	InvalidRCONCommandErr   = errors.New("invalid rcon command")
+	ForbiddenRCONCommandErr = errors.New("rcon command not allowed")
+)
+
+// Commands members can run when the guild did not configure an allowlist.
+var DefaultRCONCommands = []string{
+	"kick",
+	"mp_fraglimit",
+	"mp_friendlyfire",
+	"mp_timelimit",
+	"mp_weaponstay",
+	"status",
+	"users",
+}
+
+// Returns the lowercased name of an rcon command after checking it's a
+// single command. Commands touching cvars managed by HLDSBot are rejected
+// to not lose track of the server.
+func RCONCommandName(command string) (string, error) {
+	if strings.ContainsFunc(command, unicode.IsControl) || strings.Contains(command, ";") {
+		return "", fmt.Errorf("%w: only a single command is allowed", InvalidRCONCommandErr)
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: empty command", InvalidRCONCommandErr)
+	}
+
+	name := strings.ToLower(strings.Trim(fields[0], `"`))
+	if hlds.IsGeneratedCVar(name) {
+		return "", fmt.Errorf("%w: '%s' is managed by HLDSBot", ForbiddenRCONCommandErr, name)
+	}
+
+	return name, nil
+}
+
+// Returns true if one of the given roles is allowed to run the command. The
+// guild ID is the ID of its @everyone role.
+func (guild Guild) AllowsRCONCommand(guildID string, roleIDs []string, name string) bool {
+	if guild.RCONAllowlist == nil {
+		return slices.Contains(DefaultRCONCommands, name)
+	}
+
+	for _, roleID := range append([]string{guildID}, roleIDs...) {
+		if slices.Contains(guild.RCONAllowlist[roleID], name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replaces the rcon commands allowed for a role, an empty list removes the
+// role from the allowlist.
+func (s *Settings) SetGuildRCONCommands(guildID, roleID string, commands []string) error {
+	var names = make([]string, 0, len(commands))
+	for _, v := range commands {
+		name, err := RCONCommandName(v)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return s.updateGuild(guildID, func(guild *Guild) error {
+		if guild.RCONAllowlist == nil {
+			guild.RCONAllowlist = make(map[string][]string)
+		}
+
+		if len(names) == 0 {
+			delete(guild.RCONAllowlist, roleID)
+		} else {
+			guild.RCONAllowlist[roleID] = names
+		}
+
+		return nil
+	})
+}
+
+// A single rcon command sent through the bot.
+type RCONAuditEntry struct {
+	Time     time.Time     `json:"time"`
+	GuildID  string        `json:"guild_id"`
+	UserID   string        `json:"user_id"`
+	Username string        `json:"username"`
+	ServerID hlds.ServerID `json:"server_id"`
+	Command  string        `json:"command"`
+	Allowed  bool          `json:"allowed"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Appends the entry to dataDir/rcon_audit.jsonl.
+func (s *Settings) AuditRCON(entry RCONAuditEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit entry: %w", err)
+	}
+
+	s.auditMutex.Lock()
+	defer s.auditMutex.Unlock()
+
+	f, err := os.OpenFile(
+		filepath.Join(s.dataDir, "rcon_audit.jsonl"),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0o600,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to open audit trail: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("unable to write audit entry: %w", err)
+	}
+
+	return nil
+}
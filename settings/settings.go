@@ -0,0 +1,344 @@
+// Package settings holds operator and guild level configuration, persisted
+// as JSON files in a data directory.
+package settings
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hldsbot/hlds"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	UnknownPresetErr = errors.New("unknown preset")
+	InvalidGuildErr  = errors.New("invalid guild ID")
+)
+
+const DefaultPreset = "playtest"
+
+// Names of the layers, in order of precedence. See CVarLayers.
+const (
+	LayerImage   = "image defaults"
+	LayerGuild   = "guild overrides"
+	LayerPreset  = "preset"
+	LayerRequest = "request"
+)
+
+// A named set of settings users can pick from when starting a server.
+type Preset struct {
+	Description string     `json:"description"`
+	CVars       hlds.CVars `json:"cvars"`
+	Plugins     []string   `json:"plugins"` // names of plugin bundles to enable
+
+	// Match presets restart the map after the warmup, post the scoreboard
+	// and shut the server down after intermission.
+	Match         bool `json:"match"`
+	WarmupSeconds int  `json:"warmup_seconds"`
+}
+
+func (preset Preset) Warmup() time.Duration {
+	return time.Duration(preset.WarmupSeconds) * time.Second
+}
+
+// Per-Discord-server configuration.
+type Guild struct {
+	CVars hlds.CVars `json:"cvars"`
+
+	// text/template used to render the server hostname and motd.txt, empty
+	// to use the defaults.
+	HostnameTemplate string `json:"hostname_template"`
+	MOTDTemplate     string `json:"motd_template"`
+
+	// Role ID to names of the rcon commands members with that role can run,
+	// nil to use DefaultRCONCommands.
+	RCONAllowlist map[string][]string `json:"rcon_allowlist"`
+}
+
+type Settings struct {
+	dataDir       string
+	imageDefaults hlds.CVars
+	presets       map[string]Preset
+	plugins       map[string]hlds.PluginBundle
+
+	guildsMutex sync.Mutex
+	auditMutex  sync.Mutex
+}
+
+//go:embed presets.json
+var defaultPresets []byte
+
+// Presets are read from dataDir/presets.json if present, embedded defaults
+// are used otherwise. Plugin bundles are read from dataDir/plugins, see
+// hlds.PluginBundle. imageDefaults are the cvars set by the server.cfg baked
+// in the HLDS image.
+func Load(dataDir string, imageDefaults hlds.CVars) (*Settings, error) {
+	if err := os.MkdirAll(filepath.Join(dataDir, "guilds"), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create data dir: %w", err)
+	}
+
+	presetsJSON, err := os.ReadFile(filepath.Join(dataDir, "presets.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		presetsJSON = defaultPresets
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read presets: %w", err)
+	}
+
+	var presets map[string]Preset
+	if err := json.Unmarshal(presetsJSON, &presets); err != nil {
+		return nil, fmt.Errorf("unable to parse presets: %w", err)
+	}
+
+	if _, ok := presets[DefaultPreset]; !ok {
+		return nil, fmt.Errorf("missing default preset '%s'", DefaultPreset)
+	}
+
+	plugins, err := hlds.LoadPluginBundles(filepath.Join(dataDir, "plugins"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load plugin bundles: %w", err)
+	}
+
+	for name, preset := range presets {
+		if err := validateCVars(preset.CVars); err != nil {
+			return nil, fmt.Errorf("invalid preset '%s': %w", name, err)
+		}
+
+		if preset.WarmupSeconds < 0 {
+			return nil, fmt.Errorf("invalid preset '%s': negative warmup", name)
+		}
+
+		for _, v := range preset.Plugins {
+			if _, ok := plugins[v]; !ok {
+				return nil, fmt.Errorf("invalid preset '%s': %w: '%s'", name, hlds.UnknownPluginBundleErr, v)
+			}
+		}
+	}
+
+	return &Settings{
+		dataDir:       dataDir,
+		imageDefaults: imageDefaults,
+		presets:       presets,
+		plugins:       plugins,
+	}, nil
+}
+
+func validateCVars(cvars hlds.CVars) error {
+	for k, v := range cvars {
+		if err := validateCVar(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateCVar(key, value string) error {
+	if hlds.IsGeneratedCVar(key) {
+		return fmt.Errorf("cvar '%s' is set by HLDSBot and cannot be overridden", key)
+	}
+
+	return hlds.ValidateCVar(key, value)
+}
+
+// Sorted list of preset names.
+func (s *Settings) PresetNames() []string {
+	var ret = make([]string, 0, len(s.presets))
+	for k := range s.presets {
+		ret = append(ret, k)
+	}
+	slices.Sort(ret)
+
+	return ret
+}
+
+func (s *Settings) Preset(name string) (Preset, bool) {
+	preset, ok := s.presets[name]
+	return preset, ok
+}
+
+// Sorted list of registered plugin bundles names.
+func (s *Settings) PluginBundleNames() []string {
+	var ret = make([]string, 0, len(s.plugins))
+	for k := range s.plugins {
+		ret = append(ret, k)
+	}
+	slices.Sort(ret)
+
+	return ret
+}
+
+// Returns the plugin bundles enabled by the preset and the extra bundles
+// requested for a single server.
+func (s *Settings) PluginBundles(presetName string, extra []string) ([]hlds.PluginBundle, error) {
+	preset, ok := s.presets[presetName]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", UnknownPresetErr, presetName)
+	}
+
+	var ret = make([]hlds.PluginBundle, 0, len(preset.Plugins)+len(extra))
+	for _, name := range append(slices.Clone(preset.Plugins), extra...) {
+		bundle, ok := s.plugins[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s'", hlds.UnknownPluginBundleErr, name)
+		}
+
+		ret = append(ret, bundle)
+	}
+
+	return ret, nil
+}
+
+// Snowflakes only, this ends up in a path.
+func (s *Settings) guildPath(guildID string) (string, error) {
+	if guildID == "" || strings.Trim(guildID, "0123456789") != "" {
+		return "", InvalidGuildErr
+	}
+
+	return filepath.Join(s.dataDir, "guilds", guildID+".json"), nil
+}
+
+// Returns the guild configuration, or an empty one if the guild never
+// changed anything or there is no guild.
+func (s *Settings) Guild(guildID string) (Guild, error) {
+	s.guildsMutex.Lock()
+	defer s.guildsMutex.Unlock()
+
+	return s.readGuild(guildID)
+}
+
+func (s *Settings) readGuild(guildID string) (Guild, error) {
+	var ret = Guild{CVars: hlds.NewCVars()}
+
+	// Commands sent in DMs have no guild.
+	if guildID == "" {
+		return ret, nil
+	}
+
+	path, err := s.guildPath(guildID)
+	if err != nil {
+		return ret, err
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	} else if err != nil {
+		return ret, fmt.Errorf("unable to read guild settings: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		return ret, fmt.Errorf("unable to parse guild settings: %w", err)
+	}
+
+	if ret.CVars == nil {
+		ret.CVars = hlds.NewCVars()
+	}
+
+	return ret, nil
+}
+
+func (s *Settings) writeGuild(guildID string, guild Guild) error {
+	path, err := s.guildPath(guildID)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(guild, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode guild settings: %w", err)
+	}
+
+	// Write then rename to avoid truncating the settings on failure.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return fmt.Errorf("unable to write guild settings: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to write guild settings: %w", err)
+	}
+
+	return nil
+}
+
+// Applies f to the guild configuration and persists the result.
+func (s *Settings) updateGuild(guildID string, f func(*Guild) error) error {
+	s.guildsMutex.Lock()
+	defer s.guildsMutex.Unlock()
+
+	guild, err := s.readGuild(guildID)
+	if err != nil {
+		return err
+	}
+
+	if err := f(&guild); err != nil {
+		return err
+	}
+
+	return s.writeGuild(guildID, guild)
+}
+
+func (s *Settings) SetGuildCVar(guildID, key, value string) error {
+	if err := validateCVar(key, value); err != nil {
+		return err
+	}
+
+	return s.updateGuild(guildID, func(guild *Guild) error {
+		guild.CVars[key] = value
+		return nil
+	})
+}
+
+func (s *Settings) UnsetGuildCVar(guildID, key string) error {
+	return s.updateGuild(guildID, func(guild *Guild) error {
+		delete(guild.CVars, key)
+		return nil
+	})
+}
+
+// Templates are not validated here, the caller knows what data they will be
+// rendered with.
+func (s *Settings) SetGuildHostnameTemplate(guildID, tpl string) error {
+	return s.updateGuild(guildID, func(guild *Guild) error {
+		guild.HostnameTemplate = tpl
+		return nil
+	})
+}
+
+func (s *Settings) SetGuildMOTDTemplate(guildID, tpl string) error {
+	return s.updateGuild(guildID, func(guild *Guild) error {
+		guild.MOTDTemplate = tpl
+		return nil
+	})
+}
+
+// Returns the cvar layers to apply to a server, in order of precedence:
+// image defaults, guild overrides, preset, then per-request cvars.
+func (s *Settings) CVarLayers(guildID, presetName string, request hlds.CVars) ([]hlds.CVarLayer, error) {
+	preset, ok := s.presets[presetName]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", UnknownPresetErr, presetName)
+	}
+
+	guild, err := s.Guild(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get guild settings: %w", err)
+	}
+
+	if err := validateCVars(request); err != nil {
+		return nil, err
+	}
+
+	return []hlds.CVarLayer{
+		{Name: LayerImage, CVars: s.imageDefaults},
+		{Name: LayerGuild, CVars: guild.CVars},
+		{Name: LayerPreset + " " + presetName, CVars: preset.CVars},
+		{Name: LayerRequest, CVars: request},
+	}, nil
+}
@@ -0,0 +1,114 @@
+package settings_test
+
+import (
+	"hldsbot/hlds"
+	"hldsbot/settings"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCVarLayers(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		guildID = "1234"
+	)
+
+	s, err := settings.Load(dir, hlds.CVars{"mp_fraglimit": "25", "mp_timelimit": "15"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetGuildCVar(guildID, "mp_fraglimit", "10"))
+	for _, key := range []string{"rcon_password", "RCON_Password", "sv_downloadurl", "SV_DownloadURL"} {
+		require.Error(t, s.SetGuildCVar(guildID, key, "foo"), "generated cvars cannot be set: %s", key)
+	}
+	require.Error(t, s.SetGuildCVar(guildID, "motdfile", "server.cfg"), "unknown cvars cannot be set")
+	require.ErrorIs(t, s.SetGuildCVar("../1234", "mp_fraglimit", "10"), settings.InvalidGuildErr)
+
+	// Reload from disk.
+	s, err = settings.Load(dir, hlds.CVars{"mp_fraglimit": "25", "mp_timelimit": "15"})
+	require.NoError(t, err)
+
+	layers, err := s.CVarLayers(guildID, settings.DefaultPreset, hlds.CVars{"mp_timelimit": "5"})
+	require.NoError(t, err)
+
+	resolved := hlds.ResolveCVars(layers)
+	require.Equal(t, "10", resolved["mp_fraglimit"].Value)
+	require.Equal(t, settings.LayerGuild, resolved["mp_fraglimit"].Layer)
+	require.Equal(t, "5", resolved["mp_timelimit"].Value)
+	require.Equal(t, settings.LayerRequest, resolved["mp_timelimit"].Layer)
+
+	require.NoError(t, s.UnsetGuildCVar(guildID, "mp_fraglimit"))
+	layers, err = s.CVarLayers(guildID, settings.DefaultPreset, nil)
+	require.NoError(t, err)
+	require.Equal(t, "25", hlds.ResolveCVars(layers)["mp_fraglimit"].Value)
+
+	_, err = s.CVarLayers(guildID, "nope", nil)
+	require.ErrorIs(t, err, settings.UnknownPresetErr)
+}
+
+func TestPluginBundles(t *testing.T) {
+	var dir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "plugins/amxx/addons/amxmodx"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "presets.json"), []byte(`{
+		"playtest": {"plugins": ["amxx"]}
+	}`), 0o644))
+
+	s, err := settings.Load(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"amxx"}, s.PluginBundleNames())
+
+	bundles, err := s.PluginBundles(settings.DefaultPreset, []string{"amxx"})
+	require.NoError(t, err)
+	require.Len(t, bundles, 2, "duplicates are handled by hlds.ServerConfig")
+	require.Equal(t, filepath.Join(dir, "plugins/amxx"), bundles[0].Path)
+
+	_, err = s.PluginBundles(settings.DefaultPreset, []string{"nope"})
+	require.ErrorIs(t, err, hlds.UnknownPluginBundleErr)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "presets.json"), []byte(`{
+		"playtest": {"plugins": ["nope"]}
+	}`), 0o644))
+	_, err = settings.Load(dir, nil)
+	require.ErrorIs(t, err, hlds.UnknownPluginBundleErr, "presets cannot reference unknown bundles")
+}
+
+func TestRCONAllowlist(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		guildID = "1234"
+	)
+
+	s, err := settings.Load(dir, nil)
+	require.NoError(t, err)
+
+	guild, err := s.Guild(guildID)
+	require.NoError(t, err)
+	require.True(t, guild.AllowsRCONCommand(guildID, nil, "status"), "defaults apply to everyone")
+	require.False(t, guild.AllowsRCONCommand(guildID, nil, "quit"))
+
+	require.NoError(t, s.SetGuildRCONCommands(guildID, "42", []string{"Quit", "status"}))
+	require.ErrorIs(t, s.SetGuildRCONCommands(guildID, "42", []string{"rcon_password"}), settings.ForbiddenRCONCommandErr)
+
+	guild, err = s.Guild(guildID)
+	require.NoError(t, err)
+	require.True(t, guild.AllowsRCONCommand(guildID, []string{"42"}, "quit"))
+	require.False(t, guild.AllowsRCONCommand(guildID, nil, "status"), "defaults are replaced by the allowlist")
+
+	require.NoError(t, s.SetGuildRCONCommands(guildID, "42", nil))
+	guild, err = s.Guild(guildID)
+	require.NoError(t, err)
+	require.False(t, guild.AllowsRCONCommand(guildID, []string{"42"}, "status"), "an emptied allowlist allows nothing")
+}
+
+func TestRCONCommandName(t *testing.T) {
+	name, err := settings.RCONCommandName(`  MP_Timelimit "20"`)
+	require.NoError(t, err)
+	require.Equal(t, "mp_timelimit", name)
+
+	for _, v := range []string{"", "status; quit", "status\nquit", "sv_password", `"rcon_password"`} {
+		_, err := settings.RCONCommandName(v)
+		require.Error(t, err, v)
+	}
+}
@@ -0,0 +1,374 @@
+package twhl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hldsbot/hlds"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Must be on the same filesystem as the content store, cached trees are
+// hardlinks to its objects.
+const CacheDir = hlds.UserContentDir + "/cache"
+
+const (
+	DefaultCacheMaxSize = 4 << 30
+	DefaultCacheMaxAge  = 14 * 24 * time.Hour
+)
+
+var DefaultCache = NewCache(CacheDir, DefaultCacheMaxSize, DefaultCacheMaxAge)
+
+// Extracted Vault items, keyed by item ID, last update and selected maps so
+// an updated item is never served from the cache. Entries are
+// <dir>/<key>/meta.json and <dir>/<key>/tree, the tree is copied using
+// hardlinks so hits cost neither a download nor an extraction. Items needing
+// a map choice keep their archive as <dir>/<key>/archive instead so the
+// picked maps can be extracted without downloading it again.
+// The mtime of meta.json is the last time the entry was used, the least
+// recently used entries are evicted first.
+type Cache struct {
+	dir     string
+	maxSize int64         // sum of the size of the trees, 0 means no limit
+	maxAge  time.Duration // since last use, 0 means no limit
+
+	mutex sync.Mutex
+}
+
+func NewCache(dir string, maxSize int64, maxAge time.Duration) *Cache {
+	return &Cache{dir: dir, maxSize: maxSize, maxAge: maxAge}
+}
+
+type cacheEntry struct {
+	Map    VaultMap `json:"map"`              // Dir is not set
+	Choice []string `json:"choice,omitempty"` // set when no map was selected and one must be
+	Size   int64    `json:"size"`
+}
+
+func cacheKey(item VaultItem, mapNames []string) string {
+	selection := "default"
+	if len(mapNames) > 0 {
+		hash := sha256.Sum256([]byte(strings.Join(mapNames, "\n")))
+		selection = hex.EncodeToString(hash[:8])
+	}
+
+	return fmt.Sprintf("%d-%d-%s", item.ID, item.UpdatedAt.Unix(), selection)
+}
+
+// Returns a copy of the cached map in a new dir under dstParent, or a
+// *MapChoiceError if the item is known to need a map selection.
+func (cache *Cache) Get(item VaultItem, mapNames []string, dstParent string) (VaultMap, bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	var (
+		entryDir = filepath.Join(cache.dir, cacheKey(item, mapNames))
+		metaPath = filepath.Join(entryDir, "meta.json")
+	)
+	entry, err := readCacheEntry(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return VaultMap{}, false, nil
+	}
+	if err != nil {
+		return VaultMap{}, false, err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(metaPath, now, now); err != nil {
+		log.Error().Err(err).Str("path", metaPath).Msg("unable to touch cache entry")
+	}
+
+	if len(entry.Choice) > 0 {
+		return VaultMap{}, true, &MapChoiceError{Item: entry.Map.Item, Maps: entry.Choice}
+	}
+
+	dstDir, err := os.MkdirTemp(dstParent, "")
+	if err != nil {
+		return VaultMap{}, false, fmt.Errorf("unable to create temp dir: %w", err)
+	}
+
+	if err := hlds.LinkTree(filepath.Join(entryDir, "tree"), dstDir); err != nil {
+		if err := os.RemoveAll(dstDir); err != nil {
+			log.Error().Err(err).Msg("unable to remove partial copy of cache entry")
+		}
+		return VaultMap{}, false, fmt.Errorf("unable to copy cache entry: %w", err)
+	}
+
+	ret := entry.Map
+	ret.Dir = dstDir
+
+	return ret, true, nil
+}
+
+// Stores a copy of vaultMap.Dir, which must have been imported to the
+// content store.
+func (cache *Cache) Put(vaultMap VaultMap, mapNames []string) error {
+	var entry = cacheEntry{Map: vaultMap}
+	entry.Map.Dir = ""
+
+	return cache.put(vaultMap.Item, mapNames, entry, vaultMap.Dir, "")
+}
+
+// Remembers that the item contains multiple maps and one must be picked,
+// along with a copy of its archive, see Archive.
+func (cache *Cache) PutChoice(errChoice *MapChoiceError, archivePath string) error {
+	return cache.put(errChoice.Item, nil, cacheEntry{
+		Map:    VaultMap{Item: errChoice.Item},
+		Choice: errChoice.Maps,
+	}, "", archivePath)
+}
+
+// Returns a copy of the archive stored by PutChoice, the caller must remove
+// it once done.
+func (cache *Cache) Archive(item VaultItem) (string, bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	var (
+		entryDir = filepath.Join(cache.dir, cacheKey(item, nil))
+		src      = filepath.Join(entryDir, "archive")
+	)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("unable to stat cached archive: %w", err)
+	}
+
+	// Linked next to the entry so evicting it doesn't pull the archive
+	// from under the caller.
+	f, err := os.CreateTemp(cache.dir, ".tmp-*.archive")
+	if err != nil {
+		return "", false, fmt.Errorf("unable to create temp file: %w", err)
+	}
+	dst := f.Name()
+	if err := f.Close(); err != nil {
+		return "", false, fmt.Errorf("unable to close temp file: %w", err)
+	}
+
+	if err := os.Remove(dst); err != nil {
+		return "", false, fmt.Errorf("unable to replace temp file: %w", err)
+	}
+	if err := os.Link(src, dst); err != nil {
+		return "", false, fmt.Errorf("unable to copy cached archive: %w", err)
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(entryDir, "meta.json"), now, now); err != nil {
+		log.Error().Err(err).Str("path", entryDir).Msg("unable to touch cache entry")
+	}
+
+	return dst, true, nil
+}
+
+func (cache *Cache) put(item VaultItem, mapNames []string, entry cacheEntry, srcDir, archivePath string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if err := os.MkdirAll(cache.dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create cache dir: %w", err)
+	}
+
+	// Build the entry aside and move it in place once complete.
+	tmpDir, err := os.MkdirTemp(cache.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Error().Err(err).Msg("unable to remove temporary cache entry")
+		}
+	}()
+
+	if srcDir != "" {
+		if err := hlds.LinkTree(srcDir, filepath.Join(tmpDir, "tree")); err != nil {
+			return fmt.Errorf("unable to copy to cache entry: %w", err)
+		}
+
+		size, err := treeSize(filepath.Join(tmpDir, "tree"))
+		if err != nil {
+			return err
+		}
+		entry.Size = size
+	}
+
+	if archivePath != "" {
+		size, err := copyFile(archivePath, filepath.Join(tmpDir, "archive"))
+		if err != nil {
+			return fmt.Errorf("unable to copy archive to cache entry: %w", err)
+		}
+		entry.Size += size
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to encode cache entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "meta.json"), buf, 0o644); err != nil {
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+
+	entryDir := filepath.Join(cache.dir, cacheKey(item, mapNames))
+	if err := os.RemoveAll(entryDir); err != nil {
+		return fmt.Errorf("unable to replace cache entry: %w", err)
+	}
+	if err := os.Rename(tmpDir, entryDir); err != nil {
+		return fmt.Errorf("unable to store cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// Removes the entries that were not used for maxAge, then the least
+// recently used ones until the cache fits in maxSize. Freed content is
+// reclaimed by the next hlds.ContentStore garbage collection.
+func (cache *Cache) Evict() error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	dirEntries, err := os.ReadDir(cache.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list cache entries: %w", err)
+	}
+
+	type usage struct {
+		dir      string
+		size     int64
+		lastUsed time.Time
+	}
+	var (
+		entries []usage
+		total   int64
+		errs    []error
+	)
+	for _, v := range dirEntries {
+		dir := filepath.Join(cache.dir, v.Name())
+		if !v.IsDir() || strings.HasPrefix(v.Name(), ".tmp-") {
+			continue
+		}
+
+		metaPath := filepath.Join(dir, "meta.json")
+		info, err := os.Stat(metaPath)
+		if err != nil {
+			// Entries are moved in place complete, this is garbage.
+			errs = append(errs, removeCacheEntry(dir))
+			continue
+		}
+
+		entry, err := readCacheEntry(metaPath)
+		if err != nil {
+			errs = append(errs, removeCacheEntry(dir))
+			continue
+		}
+
+		entries = append(entries, usage{dir: dir, size: entry.Size, lastUsed: info.ModTime()})
+		total += entry.Size
+	}
+
+	slices.SortFunc(entries, func(a, b usage) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+
+	now := time.Now()
+	for _, v := range entries {
+		expired := cache.maxAge > 0 && now.Sub(v.lastUsed) > cache.maxAge
+		if !expired && (cache.maxSize <= 0 || total <= cache.maxSize) {
+			continue
+		}
+
+		log.Info().Str("entry", filepath.Base(v.dir)).Int64("size", v.size).Bool("expired", expired).Msg("Evicting cached Vault item.")
+		if err := removeCacheEntry(v.dir); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		total -= v.size
+	}
+
+	return errors.Join(errs...)
+}
+
+func readCacheEntry(path string) (cacheEntry, error) {
+	var entry cacheEntry
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return entry, fmt.Errorf("unable to read cache entry: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return entry, fmt.Errorf("unable to parse cache entry '%s': %w", path, err)
+	}
+
+	return entry, nil
+}
+
+func removeCacheEntry(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to remove cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// Downloads are not in the cache filesystem, links are only attempted.
+func copyFile(src, dst string) (int64, error) {
+	if err := os.Link(src, dst); err == nil {
+		info, err := os.Stat(dst)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+
+	return size, out.Close()
+}
+
+func treeSize(dir string) (int64, error) {
+	var total int64
+	if err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("unable to compute cache entry size: %w", err)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,105 @@
+package twhl_test
+
+import (
+	"errors"
+	"hldsbot/twhl"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		base  = t.TempDir()
+		cache = twhl.NewCache(filepath.Join(base, "cache"), 10, time.Hour)
+		item  = twhl.VaultItem{ID: 42, UpdatedAt: time.Unix(1700000000, 0)}
+		src   = filepath.Join(base, "src")
+	)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "maps"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "maps/foo.bsp"), []byte("12345678"), 0o644))
+
+	_, ok, err := cache.Get(item, nil, base)
+	require.NoError(t, err)
+	require.False(t, ok, "empty cache")
+
+	require.NoError(t, cache.Put(twhl.VaultMap{
+		Item:             item,
+		Dir:              src,
+		MapName:          "foo",
+		Maps:             []string{"foo"},
+		MissingResources: []string{"sound/bar.wav"},
+	}, nil))
+
+	vaultMap, ok, err := cache.Get(item, nil, base)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotEqual(t, src, vaultMap.Dir)
+	require.Equal(t, "foo", vaultMap.MapName)
+	require.Equal(t, []string{"sound/bar.wav"}, vaultMap.MissingResources)
+
+	infoSrc, err := os.Stat(filepath.Join(src, "maps/foo.bsp"))
+	require.NoError(t, err)
+	infoCopy, err := os.Stat(filepath.Join(vaultMap.Dir, "maps/foo.bsp"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(infoSrc, infoCopy), "copies are hardlinks")
+
+	_, ok, err = cache.Get(item, []string{"foo"}, base)
+	require.NoError(t, err)
+	require.False(t, ok, "selection is part of the key")
+
+	updated := item
+	updated.UpdatedAt = item.UpdatedAt.Add(time.Minute)
+	_, ok, err = cache.Get(updated, nil, base)
+	require.NoError(t, err)
+	require.False(t, ok, "updated items are not served from the cache")
+
+	_, ok, err = cache.Archive(item)
+	require.NoError(t, err)
+	require.False(t, ok, "archives are only kept for map choices")
+
+	var (
+		choice      = twhl.VaultItem{ID: 43, UpdatedAt: item.UpdatedAt}
+		archivePath = filepath.Join(base, "choice.zip")
+	)
+	require.NoError(t, os.WriteFile(archivePath, []byte("PK"), 0o644))
+	require.NoError(t, cache.PutChoice(&twhl.MapChoiceError{Item: choice, Maps: []string{"a", "b"}}, archivePath))
+	require.NoError(t, os.Remove(archivePath))
+	_, ok, err = cache.Get(choice, nil, base)
+	require.True(t, ok)
+	var errChoice *twhl.MapChoiceError
+	require.True(t, errors.As(err, &errChoice))
+	require.Equal(t, []string{"a", "b"}, errChoice.Maps)
+
+	archiveCopy, ok, err := cache.Archive(choice)
+	require.NoError(t, err)
+	require.True(t, ok, "picking maps doesn't download the archive again")
+	content, err := os.ReadFile(archiveCopy)
+	require.NoError(t, err)
+	require.Equal(t, "PK", string(content))
+	require.NoError(t, os.Remove(archiveCopy))
+
+	// The choice entry is more recent and only holds 2 bytes, adding 8 more
+	// bytes goes over the limit and evicts the least recently used tree.
+	other := twhl.VaultItem{ID: 44, UpdatedAt: item.UpdatedAt}
+	require.NoError(t, cache.Put(twhl.VaultMap{Item: other, Dir: src}, nil))
+	require.NoError(t, cache.Evict())
+
+	_, ok, err = cache.Get(item, nil, base)
+	require.NoError(t, err)
+	require.False(t, ok, "least recently used entry is evicted")
+	_, ok, _ = cache.Get(other, nil, base)
+	require.True(t, ok)
+	_, ok, _ = cache.Get(choice, nil, base)
+	require.True(t, ok)
+
+	expiring := twhl.NewCache(filepath.Join(base, "cache"), 0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	require.NoError(t, expiring.Evict())
+	entries, err := os.ReadDir(filepath.Join(base, "cache"))
+	require.NoError(t, err)
+	require.Empty(t, entries, "expired entries are evicted")
+}
@@ -23,6 +23,8 @@ const (
 	// Instead of building strings from the uploads dir, rely on the download
 	// proxy URL.
 	downloadURLTemplate = "https://twhl.info/vault/download/%d"
+
+	vaultItemURLTemplate = "https://twhl.info/vault/view/%d"
 )
 
 // Hardcoding IDs, sue me.
@@ -56,14 +58,29 @@ func apiURL(path string, kvs map[string]string) (string, error) {
 	return ret.String(), nil
 }
 
-// Downloads a Vault item to a temporary file and returns its path.
-// The caller is responsible for removing the created file.
-func (client *Client) DownloadVaultItem(ctx context.Context, id int) (string, error) {
+// Downloads a Vault item to a temporary file and returns the item and the
+// file path. The caller is responsible for removing the created file.
+func (client *Client) DownloadVaultItem(ctx context.Context, id int) (VaultItem, string, error) {
+	item, err := client.GetVaultMapItem(ctx, id)
+	if err != nil {
+		return item, "", err
+	}
+
+	path, err := client.DownloadVaultItemArchive(ctx, item)
+	if err != nil {
+		return item, "", err
+	}
+
+	return item, path, nil
+}
+
+// Returns ErrWrongCategory if the item is not an HLDM map.
+func (client *Client) GetVaultMapItem(ctx context.Context, id int) (VaultItem, error) {
 	log.Info().Int("id", id).Msg("Vault item download requested, querying API.")
 
 	item, err := client.GetVaultItem(ctx, id)
 	if err != nil {
-		return "", fmt.Errorf("unable to get vault item: %w", err)
+		return item, fmt.Errorf("unable to get vault item: %w", err)
 	}
 
 	item.ContentText, item.ContentHTML = "", "" // cleaner logs
@@ -72,11 +89,15 @@ func (client *Client) DownloadVaultItem(ctx context.Context, id int) (string, er
 	if item.EngineID != EngineIDGoldSrc ||
 		item.GameID != GameIDHLDM ||
 		item.TypeID != ItemTypeIDMap {
-		return "", ErrWrongCategory
+		return item, ErrWrongCategory
 	}
 
-	downloadURL := fmt.Sprintf(downloadURLTemplate, id)
-	log.Info().Int("id", id).Str("url", downloadURL).Msg("Downloading archive.")
+	return item, nil
+}
+
+func (client *Client) DownloadVaultItemArchive(ctx context.Context, item VaultItem) (string, error) {
+	downloadURL := fmt.Sprintf(downloadURLTemplate, item.ID)
+	log.Info().Int("id", item.ID).Str("url", downloadURL).Msg("Downloading archive.")
 	path, err := client.DownloadToFile(ctx, downloadURL)
 	if err != nil {
 		return "", fmt.Errorf("unable to download file: %w", err)
@@ -117,8 +138,17 @@ func (client *Client) DownloadToFile(ctx context.Context, url string) (string, e
 }
 
 func (client *Client) GetVaultItem(ctx context.Context, id int) (VaultItem, error) {
-	var zero VaultItem
-	url, err := apiURL("/vault-items", map[string]string{"id": strconv.Itoa(id)})
+	return getOne[VaultItem](ctx, client, "/vault-items", id)
+}
+
+func (client *Client) GetUser(ctx context.Context, id int) (User, error) {
+	return getOne[User](ctx, client, "/users", id)
+}
+
+// Queries a list endpoint by ID and expects a single item in return.
+func getOne[T any](ctx context.Context, client *Client, path string, id int) (T, error) {
+	var zero T
+	url, err := apiURL(path, map[string]string{"id": strconv.Itoa(id)})
 	if err != nil {
 		return zero, fmt.Errorf("unable to create request URL: %w", err)
 	}
@@ -140,7 +170,7 @@ func (client *Client) GetVaultItem(ctx context.Context, id int) (VaultItem, erro
 
 	var (
 		dec   = json.NewDecoder(res.Body)
-		items []VaultItem
+		items []T
 	)
 	if err := dec.Decode(&items); err != nil {
 		return zero, fmt.Errorf("unable to parse API response: %w", err)
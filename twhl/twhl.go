@@ -2,20 +2,104 @@ package twhl
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hldsbot/hlds"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
-// Returns the path to the extracted data ready to be mounted as valve_addon,
-// and name of the map found in the archive.
-func FetchAndExtractVaultMap(ctx context.Context, itemID int) (string, string, error) {
+type VaultMap struct {
+	Item    VaultItem
+	Author  User
+	Dir     string   // extracted data ready to be mounted as valve_addon
+	MapName string   // name of the startup map
+	Maps    []string // names of the extracted maps, MapName first
+
+	// Files referenced by the maps but absent from the archive, they may be
+	// part of the base game.
+	MissingResources []string
+	MissingTextures  []string
+	Report           hlds.ExtractionReport
+	Limits           map[string]hlds.LimitsReport // by map name
+}
+
+// Returned when the archive contains more than one map and none were
+// selected. Unwraps to hlds.MultipleBSPErr.
+type MapChoiceError struct {
+	Item VaultItem
+	Maps []string // sorted
+}
+
+func (err *MapChoiceError) Error() string {
+	return fmt.Sprintf("%s: %s", hlds.MultipleBSPErr, strings.Join(err.Maps, ", "))
+}
+
+func (err *MapChoiceError) Unwrap() error {
+	return hlds.MultipleBSPErr
+}
+
+// mapNames selects the maps to extract from archives containing more than
+// one, the first one being the startup map. If the archive contains multiple
+// maps and mapNames is empty a *MapChoiceError is returned.
+// Results are served from DefaultCache when the item was not updated since.
+func FetchAndExtractVaultMap(ctx context.Context, itemID int, mapNames []string) (VaultMap, error) {
+	var zero VaultMap
+
 	client := NewClient()
-	archivePath, err := client.DownloadVaultItem(ctx, itemID)
+	item, err := client.GetVaultMapItem(ctx, itemID)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to download vault item #%d: %w", itemID, err)
+		return zero, fmt.Errorf("unable to download vault item #%d: %w", itemID, err)
+	}
+
+	if _, err := os.Stat(hlds.UserContentDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(hlds.UserContentDir, 0o755); err != nil {
+			return zero, fmt.Errorf("unable to create dir '%s': %w", hlds.UserContentDir, err)
+		}
+	}
+
+	if err := DefaultCache.Evict(); err != nil {
+		log.Error().Err(err).Msg("unable to evict cached vault items")
+	}
+
+	cached, ok, err := DefaultCache.Get(item, mapNames, hlds.UserContentDir)
+	if ok {
+		log.Info().Int("id", itemID).Msg("Using cached vault item.")
+		cached.Item = item
+		return cached, err
+	}
+	if err != nil {
+		log.Error().Err(err).Int("id", itemID).Msg("unable to read cached vault item")
+	}
+
+	vaultMap, err := fetchAndExtract(ctx, client, item, mapNames)
+	if err != nil {
+		return zero, err
+	}
+
+	if err := DefaultCache.Put(vaultMap, mapNames); err != nil {
+		log.Error().Err(err).Int("id", itemID).Msg("unable to cache vault item")
+	}
+
+	return vaultMap, nil
+}
+
+func fetchAndExtract(ctx context.Context, client *Client, item VaultItem, mapNames []string) (VaultMap, error) {
+	var zero VaultMap
+
+	// Kept when the user had to pick maps from it.
+	archivePath, ok, err := DefaultCache.Archive(item)
+	if err != nil {
+		log.Error().Err(err).Int("id", item.ID).Msg("unable to read cached vault item archive")
+	}
+	if !ok {
+		archivePath, err = client.DownloadVaultItemArchive(ctx, item)
+		if err != nil {
+			return zero, fmt.Errorf("unable to download vault item #%d: %w", item.ID, err)
+		}
 	}
 
 	defer func() {
@@ -26,27 +110,110 @@ func FetchAndExtractVaultMap(ctx context.Context, itemID int) (string, string, e
 
 	archive, err := hlds.ReadMapArchiveFromFile(archivePath)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to read map archive: %w", err)
+		return zero, fmt.Errorf("unable to read map archive: %w", err)
 	}
 
-	if _, err := os.Stat(hlds.UserContentDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(hlds.UserContentDir, 0o755); err != nil {
-			return "", "", fmt.Errorf("unable to create dir '%s': %w", hlds.UserContentDir, err)
+	if err := selectMaps(archive, item, mapNames); err != nil {
+		if err := archive.Close(); err != nil {
+			log.Error().Err(err).Msg("unable to close map archive")
+		}
+
+		var errChoice *MapChoiceError
+		if errors.As(err, &errChoice) {
+			if err := DefaultCache.PutChoice(errChoice, archivePath); err != nil {
+				log.Error().Err(err).Int("id", item.ID).Msg("unable to cache vault item")
+			}
 		}
+
+		return zero, err
 	}
+
 	dstDir, err := os.MkdirTemp(hlds.UserContentDir, "")
 	if err != nil {
-		return "", "", fmt.Errorf("unable to create temp dir: %w", err)
+		return zero, fmt.Errorf("unable to create temp dir: %w", err)
 	}
 
-	if _, err := archive.Extract(dstDir); err != nil {
-		return "", "", fmt.Errorf("unable to extract archive: %w", err)
+	if err := extract(archive, dstDir); err != nil {
+		if err := archive.Close(); err != nil {
+			log.Error().Err(err).Msg("unable to close map archive")
+		}
+		if err := os.RemoveAll(dstDir); err != nil {
+			log.Error().Err(err).Msg("unable to remove partially extracted archive")
+		}
+		return zero, err
 	}
 
-	var mapName = archive.MapName()
+	var (
+		mapName  = archive.MapName()
+		maps     = archive.SelectedMaps()
+		missing  []string
+		textures []string
+		limits   = make(map[string]hlds.LimitsReport, len(maps))
+	)
+	for _, v := range maps {
+		if report, ok := archive.Limits(v); ok {
+			limits[v] = report
+		}
+
+		resources, _ := archive.Resources(v)
+		for _, file := range resources.Missing {
+			if !slices.Contains(missing, file) {
+				missing = append(missing, file)
+			}
+		}
+		for _, tex := range resources.MissingTextures {
+			if !slices.Contains(textures, tex) {
+				textures = append(textures, tex)
+			}
+		}
+	}
+	slices.Sort(missing)
+	slices.Sort(textures)
 	if err := archive.Close(); err != nil {
-		return "", "", fmt.Errorf("unable to close map archive: %w", err)
+		return zero, fmt.Errorf("unable to close map archive: %w", err)
+	}
+
+	// Only used for display purposes, don't fail.
+	author, err := client.GetUser(ctx, item.UserID)
+	if err != nil {
+		log.Error().Err(err).Int("id", item.UserID).Msg("unable to get vault item author")
+		author = User{ID: item.UserID, Name: fmt.Sprintf("user #%d", item.UserID)}
+	}
+
+	return VaultMap{
+		Item:    item,
+		Author:  author,
+		Dir:     dstDir,
+		MapName: mapName,
+		Maps:    maps,
+
+		MissingResources: missing,
+		MissingTextures:  textures,
+		Report:           archive.Report(),
+		Limits:           limits,
+	}, nil
+}
+
+func selectMaps(archive *hlds.MapArchive, item VaultItem, mapNames []string) error {
+	if len(mapNames) > 0 {
+		return archive.Select(mapNames...)
+	}
+
+	if maps := archive.Maps(); len(maps) > 1 {
+		return &MapChoiceError{Item: item, Maps: maps}
+	}
+
+	return nil
+}
+
+func extract(archive *hlds.MapArchive, dstDir string) error {
+	if _, err := archive.Extract(dstDir); err != nil {
+		return fmt.Errorf("unable to extract archive: %w", err)
+	}
+
+	if err := hlds.DefaultContentStore.Import(dstDir); err != nil {
+		return fmt.Errorf("unable to deduplicate extracted content: %w", err)
 	}
 
-	return dstDir, mapName, nil
+	return nil
 }
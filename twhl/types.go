@@ -1,6 +1,9 @@
 package twhl
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type VaultItem struct {
 	ID                 int       `json:"id"`
@@ -25,3 +28,12 @@ type VaultItem struct {
 	CreatedAt          time.Time `json:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at"`
 }
+
+func (item VaultItem) URL() string {
+	return fmt.Sprintf(vaultItemURLTemplate, item.ID)
+}
+
+type User struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
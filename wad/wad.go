@@ -0,0 +1,139 @@
+// Package wad reads GoldSrc WAD3 texture archives.
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hldsbot/bsp"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	InvalidWADErr   = errors.New("not a WAD3 file")
+	UnknownEntryErr = errors.New("no such entry in WAD")
+)
+
+// Entry types.
+const (
+	TypeQPic   = 0x42
+	TypeMipTex = 0x43
+	TypeFont   = 0x46
+)
+
+type header struct {
+	Magic      [4]byte
+	NumEntries int32
+	DirOffset  int32
+}
+
+type dirEntry struct {
+	Offset      int32
+	DiskSize    int32
+	Size        int32
+	Type        uint8
+	Compression uint8
+	_           uint16
+	Name        [16]byte
+}
+
+type Entry struct {
+	Name   string
+	Type   uint8
+	Offset int32
+	Size   int32
+}
+
+type WAD struct {
+	buf     []byte
+	entries []Entry
+	byName  map[string]int // lowercased name => index in entries
+}
+
+func Open(path string) (*WAD, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read WAD: %w", err)
+	}
+
+	return Parse(buf)
+}
+
+func Parse(buf []byte) (*WAD, error) {
+	r := bytes.NewReader(buf)
+
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("unable to read WAD header: %w", err)
+	}
+	if string(h.Magic[:]) != "WAD3" {
+		return nil, fmt.Errorf("%w: magic is '%s'", InvalidWADErr, h.Magic[:])
+	}
+
+	const entrySize = 32
+	if h.NumEntries < 0 || h.DirOffset < 0 ||
+		int64(h.DirOffset)+int64(h.NumEntries)*entrySize > int64(len(buf)) {
+		return nil, fmt.Errorf("%w: directory out of bounds", InvalidWADErr)
+	}
+
+	dir := make([]dirEntry, h.NumEntries)
+	if err := binary.Read(bytes.NewReader(buf[h.DirOffset:]), binary.LittleEndian, dir); err != nil {
+		return nil, fmt.Errorf("unable to read WAD directory: %w", err)
+	}
+
+	var ret = &WAD{
+		buf:     buf,
+		entries: make([]Entry, 0, len(dir)),
+		byName:  make(map[string]int, len(dir)),
+	}
+	for _, v := range dir {
+		if v.Offset < 0 || v.DiskSize < 0 || int64(v.Offset)+int64(v.DiskSize) > int64(len(buf)) {
+			return nil, fmt.Errorf("%w: entry out of bounds", InvalidWADErr)
+		}
+
+		name, _, _ := bytes.Cut(v.Name[:], []byte{0})
+		ret.byName[strings.ToLower(string(name))] = len(ret.entries)
+		ret.entries = append(ret.entries, Entry{
+			Name:   string(name),
+			Type:   v.Type,
+			Offset: v.Offset,
+			Size:   v.DiskSize,
+		})
+	}
+
+	return ret, nil
+}
+
+func (wad *WAD) Entries() []Entry {
+	return slices.Clone(wad.entries)
+}
+
+// Names of the miptex entries, the engine looks them up case-insensitively.
+func (wad *WAD) TextureNames() []string {
+	var ret = make([]string, 0, len(wad.entries))
+	for _, v := range wad.entries {
+		if v.Type == TypeMipTex {
+			ret = append(ret, v.Name)
+		}
+	}
+
+	return ret
+}
+
+func (wad *WAD) HasTexture(name string) bool {
+	i, ok := wad.byName[strings.ToLower(name)]
+	return ok && wad.entries[i].Type == TypeMipTex
+}
+
+func (wad *WAD) Texture(name string) (bsp.Texture, error) {
+	i, ok := wad.byName[strings.ToLower(name)]
+	if !ok || wad.entries[i].Type != TypeMipTex {
+		return bsp.Texture{}, fmt.Errorf("%w: '%s'", UnknownEntryErr, name)
+	}
+
+	entry := wad.entries[i]
+	return bsp.ParseMipTex(wad.buf[entry.Offset : entry.Offset+entry.Size])
+}
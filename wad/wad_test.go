@@ -0,0 +1,74 @@
+package wad_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hldsbot/wad"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func name16(name string) [16]byte {
+	var ret [16]byte
+	copy(ret[:], name)
+	return ret
+}
+
+// WAD3 with a single 16×16 texture and a qpic.
+func buildWAD(t *testing.T) []byte {
+	t.Helper()
+
+	var miptex bytes.Buffer
+	for _, v := range []any{
+		name16("CRATE01"), uint32(16), uint32(16), [4]uint32{40, 40 + 256, 40 + 256 + 64, 40 + 256 + 64 + 16},
+		make([]byte, 256+64+16+4), uint16(256), make([]byte, 768),
+	} {
+		require.NoError(t, binary.Write(&miptex, binary.LittleEndian, v))
+	}
+
+	var (
+		buf       bytes.Buffer
+		dirOffset = 12 + miptex.Len() + 4
+	)
+	for _, v := range []any{
+		[4]byte{'W', 'A', 'D', '3'}, int32(2), int32(dirOffset),
+		miptex.Bytes(), []byte("qpic"),
+		int32(12), int32(miptex.Len()), int32(miptex.Len()), uint8(wad.TypeMipTex), uint8(0), uint16(0), name16("CRATE01"),
+		int32(12 + miptex.Len()), int32(4), int32(4), uint8(wad.TypeQPic), uint8(0), uint16(0), name16("conchars"),
+	} {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, v))
+	}
+
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	w, err := wad.Parse(buildWAD(t))
+	require.NoError(t, err)
+
+	require.Len(t, w.Entries(), 2)
+	require.Equal(t, []string{"CRATE01"}, w.TextureNames())
+	require.True(t, w.HasTexture("crate01"), "lookups are case-insensitive")
+	require.False(t, w.HasTexture("conchars"), "qpics are not textures")
+
+	tex, err := w.Texture("Crate01")
+	require.NoError(t, err)
+	require.Equal(t, uint32(16), tex.Width)
+	require.True(t, tex.Embedded())
+
+	_, err = w.Texture("nope")
+	require.ErrorIs(t, err, wad.UnknownEntryErr)
+}
+
+func TestParseInvalid(t *testing.T) {
+	buf := buildWAD(t)
+	copy(buf, "WAD2")
+	_, err := wad.Parse(buf)
+	require.ErrorIs(t, err, wad.InvalidWADErr)
+
+	buf = buildWAD(t)
+	binary.LittleEndian.PutUint32(buf[4:], 1000)
+	_, err = wad.Parse(buf)
+	require.ErrorIs(t, err, wad.InvalidWADErr)
+}